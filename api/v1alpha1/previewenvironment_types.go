@@ -18,7 +18,10 @@ package v1alpha1
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -28,6 +31,9 @@ import (
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 type PreviewEnvironment struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -61,6 +67,61 @@ type PreviewEnvironmentSpec struct {
 	// +kubebuilder:validation:Required
 	// AccessSettings configuration for the access control
 	AccessSettings AccessSettings `json:"accessSettings"`
+
+	// +optional
+	// CleanupSettings configuration of what happens with instances whose pull request or branch is gone
+	CleanupSettings *CleanupSettings `json:"cleanupSettings,omitempty"`
+
+	// +optional
+	// ExpiryPolicy configuration of when instances expire, instances never expire if not set
+	ExpiryPolicy *ExpiryPolicy `json:"expiryPolicy,omitempty"`
+
+	// +optional
+	// PollInterval how often the repository is checked for new pull requests and branches
+	// uses the default of the operator if not set, useful for repositories without webhooks
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+
+	// +optional
+	// CommentTemplate go template of the comment that is kept up to date in every pull request
+	// it gets the pull request and its instances, the default template of the operator is used if not set
+	CommentTemplate *string `json:"commentTemplate,omitempty"`
+}
+
+type CleanupSettings struct {
+	// +optional
+	// +kubebuilder:validation:Enum=delete;stop
+	// StaleAction what happens with an instance once its pull request got closed or merged or its branch got deleted
+	// defaults to delete
+	StaleAction string `json:"staleAction,omitempty"`
+
+	// +optional
+	// GracePeriod how long a stale instance is kept before the stale action is applied
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+const (
+	StaleActionDelete = "delete"
+	StaleActionStop   = "stop"
+)
+
+type ExpiryPolicy struct {
+	// +optional
+	// MaxAge instances expire this long after they were created
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// +optional
+	// IdleTimeout instances expire after they did not get any requests for this duration
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// +optional
+	// ExpireAfterPRClosed instances expire this long after their pull request got closed or merged
+	// overrides the grace period of the cleanup settings
+	ExpireAfterPRClosed *metav1.Duration `json:"expireAfterPRClosed,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Enum=delete;stop
+	// Action what happens with an expired instance, defaults to delete
+	Action string `json:"action,omitempty"`
 }
 
 type BuildSettings struct {
@@ -74,11 +135,65 @@ type BuildSettings struct {
 
 	// +optional
 	// BranchWildcard is optional and can be used to specify a wildcard for branches that should be built
+	// deprecated, use BranchFilter instead
 	BranchWildcard *string `json:"branchWildcard"`
 
+	// +optional
+	// BranchFilter selects the branches that get their own instance, include patterns enable branch instances
+	BranchFilter *PatternFilter `json:"branchFilter,omitempty"`
+
+	// +optional
+	// PullRequestHeadFilter selects pull requests by the branch they come from
+	PullRequestHeadFilter *PatternFilter `json:"pullRequestHeadFilter,omitempty"`
+
+	// +optional
+	// PullRequestBaseFilter selects pull requests by the branch they target
+	PullRequestBaseFilter *PatternFilter `json:"pullRequestBaseFilter,omitempty"`
+
+	// +optional
+	// PullRequestLabel only pull requests carrying this label get an instance, removing the label removes the instance
+	// pull requests are selected by the label even if BuildAllPullRequests is false
+	PullRequestLabel *string `json:"pullRequestLabel,omitempty"`
+
+	// +optional
+	// PullRequestAuthorFilter selects pull requests by the login of their author, e.g. to exclude bots
+	PullRequestAuthorFilter *PatternFilter `json:"pullRequestAuthorFilter,omitempty"`
+
+	// +optional
+	// SkipDraftPullRequests draft pull requests do not get an instance until they are ready for review
+	SkipDraftPullRequests bool `json:"skipDraftPullRequests,omitempty"`
+
+	// +optional
+	// ForkApprovalLabel a maintainer adds this label to approve the head commit of a fork pull request at that moment
+	// later commits have to be approved again, defaults to preview-approved
+	ForkApprovalLabel *string `json:"forkApprovalLabel,omitempty"`
+
 	// +optional
 	// DockerfilePath is optional and can be used to override the default Dockerfile that is used to build the application
+	// the path is relative to the build context, defaults to Dockerfile
 	DockerfilePath *string `json:"dockerfile"`
+
+	// +optional
+	// ContextPath the subdirectory of the repository that is used as build context, e.g. services/api in a monorepo
+	// defaults to the root of the repository
+	ContextPath *string `json:"contextPath,omitempty"`
+
+	// +optional
+	// BuildArgs are passed to the build as build arguments
+	BuildArgs []BuildArg `json:"buildArgs,omitempty"`
+
+	// +optional
+	// Target the stage of a multi-stage Dockerfile that is built, defaults to the last stage
+	Target *string `json:"target,omitempty"`
+}
+
+type BuildArg struct {
+	// +kubebuilder:validation:MinLength=1
+	// Key is the name of the build argument
+	Key string `json:"key"`
+
+	// Value is the value of the build argument
+	Value string `json:"value"`
 }
 
 type GitSettings struct {
@@ -89,6 +204,25 @@ type GitSettings struct {
 	// +kubebuilder:validation:MinLength=0
 	// +kubebuilder:validation:MaxLength=63
 	Repository string `json:"repository"`
+
+	// +optional
+	// +kubebuilder:validation:Enum=github;gitlab;gitea
+	// Provider the service that hosts the repository, defaults to github
+	Provider string `json:"provider,omitempty"`
+}
+
+const (
+	GitProviderGithub = "github"
+	GitProviderGitlab = "gitlab"
+	GitProviderGitea  = "gitea"
+)
+
+// ProviderOrDefault returns the configured git provider, github if none is set
+func (g *GitSettings) ProviderOrDefault() string {
+	if g.Provider == "" {
+		return GitProviderGithub
+	}
+	return g.Provider
 }
 
 type ContainerRegistry struct {
@@ -117,8 +251,29 @@ type ApplicationSettings struct {
 	// +optional
 	// Command is optional and can be used to override the default command that is used to start the application
 	Command *string `json:"command"`
+
+	// +optional
+	// IdleTimeout instances without requests for this duration are scaled to zero
+	// the first request afterwards wakes them up again, scale down is disabled if not set
+	// the traffic is checked every 10 minutes, instances can be scaled down up to that much later
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// PreviousCommits how many previously deployed commits keep running next to the current one so they can be compared
+	// each of them is reachable on its commit path and runs its own pods, defaults to 0 which only keeps the current commit
+	PreviousCommits *int `json:"previousCommits,omitempty"`
+
+	// +optional
+	// HealthCheckPath the application is ready once a GET request to this path on its port succeeds, e.g. /health
+	// the stable url keeps serving the previous build until the new one is ready
+	// without it the application is ready once its port accepts connections
+	HealthCheckPath *string `json:"healthCheckPath,omitempty"`
 }
 
+// DefaultPreviousCommits how many previous commits keep running if the application settings do not configure it
+const DefaultPreviousCommits = 0
+
 type AccessSettings struct {
 	// +kubebuilder:validation:Required
 	// Users is a list of users that should have access to the preview environment
@@ -149,6 +304,16 @@ type PreviewEnvironmentStatus struct {
 
 	// +optional
 	Phase string `json:"phase"`
+
+	// +optional
+	// ObservedGeneration the generation of the preview environment that was last processed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// Conditions the latest observations of the state of the preview environment
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 const (
@@ -196,3 +361,201 @@ func init() {
 func (pe *PreviewEnvironment) GetOwner() string {
 	return pe.GetLabels()["owner"]
 }
+
+// StaleAction returns the configured action for stale instances
+func (pe *PreviewEnvironment) StaleAction() string {
+	if pe.Spec.CleanupSettings == nil || pe.Spec.CleanupSettings.StaleAction == "" {
+		return StaleActionDelete
+	}
+	return pe.Spec.CleanupSettings.StaleAction
+}
+
+// StaleGracePeriod returns how long stale instances are kept
+func (pe *PreviewEnvironment) StaleGracePeriod() time.Duration {
+	if pe.Spec.ExpiryPolicy != nil && pe.Spec.ExpiryPolicy.ExpireAfterPRClosed != nil {
+		return pe.Spec.ExpiryPolicy.ExpireAfterPRClosed.Duration
+	}
+	if pe.Spec.CleanupSettings == nil || pe.Spec.CleanupSettings.GracePeriod == nil {
+		return 0
+	}
+	return pe.Spec.CleanupSettings.GracePeriod.Duration
+}
+
+// BuildsPullRequests returns true if any pull request can get an instance
+func (b *BuildSettings) BuildsPullRequests() bool {
+	return b.BuildAllPullRequests || b.PullRequestLabel != nil
+}
+
+// HasPullRequestLabel returns true if the labels contain the configured pull request label
+func (b *BuildSettings) HasPullRequestLabel(labels []string) bool {
+	if b.PullRequestLabel == nil {
+		return true
+	}
+	for _, label := range labels {
+		// labels are unique regardless of their case
+		if strings.EqualFold(label, *b.PullRequestLabel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dockerfile returns the path of the Dockerfile relative to the build context
+func (b *BuildSettings) Dockerfile() string {
+	if b.DockerfilePath == nil || *b.DockerfilePath == "" {
+		return "Dockerfile"
+	}
+	return *b.DockerfilePath
+}
+
+// ValidateBuild checks that the paths stay inside of the repository and the build arguments have a name
+func (b *BuildSettings) ValidateBuild() error {
+	for name, p := range map[string]*string{"dockerfile": b.DockerfilePath, "context path": b.ContextPath} {
+		if p == nil {
+			continue
+		}
+		if strings.HasPrefix(*p, "/") || slices.Contains(strings.Split(*p, "/"), "..") {
+			return fmt.Errorf("%s %s has to be relative to the repository", name, *p)
+		}
+	}
+
+	for _, arg := range b.BuildArgs {
+		if arg.Key == "" || strings.Contains(arg.Key, "=") {
+			return fmt.Errorf("invalid build argument name %q", arg.Key)
+		}
+	}
+	return nil
+}
+
+// DefaultForkApprovalLabel approves the builds of fork pull requests if the build settings do not configure another label
+const DefaultForkApprovalLabel = "preview-approved"
+
+// HasForkApprovalLabel returns true if the labels contain the label that approves the builds of a fork pull request
+func (b *BuildSettings) HasForkApprovalLabel(labels []string) bool {
+	approvalLabel := DefaultForkApprovalLabel
+	if b.ForkApprovalLabel != nil {
+		approvalLabel = *b.ForkApprovalLabel
+	}
+
+	for _, label := range labels {
+		if strings.EqualFold(label, approvalLabel) {
+			return true
+		}
+	}
+	return false
+}
+
+// PatternFilter selects names, e.g. of branches, by glob or regex patterns
+// patterns wrapped in slashes like /^release-[0-9]+$/ are regular expressions
+// every other pattern is a glob where * matches any characters including slashes and ? matches a single character
+type PatternFilter struct {
+	// +optional
+	// Include only names matching one of the patterns are selected, all names are selected if it is empty
+	Include []string `json:"include,omitempty"`
+
+	// +optional
+	// Exclude names matching one of the patterns are never selected, takes precedence over Include
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// HasInclude returns true if the filter only selects names matching its include patterns
+func (f *PatternFilter) HasInclude() bool {
+	return f != nil && len(f.Include) > 0
+}
+
+// Validate returns an error if one of the patterns is not a valid regular expression
+func (f *PatternFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := matchPattern(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the name is selected by the filter, a nil filter selects every name
+func (f *PatternFilter) Matches(name string) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+
+	for _, pattern := range f.Exclude {
+		match, err := matchPattern(pattern, name)
+		if err != nil || match {
+			return false, err
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range f.Include {
+		match, err := matchPattern(pattern, name)
+		if err != nil || match {
+			return match, err
+		}
+	}
+	return false, nil
+}
+
+// matchPattern matches the whole name against a glob or a /regex/
+func matchPattern(pattern, name string) (bool, error) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		return re.MatchString(name), nil
+	}
+
+	glob := regexp.QuoteMeta(pattern)
+	glob = strings.ReplaceAll(glob, `\*`, ".*")
+	glob = strings.ReplaceAll(glob, `\?`, ".")
+	return regexp.MustCompile("^" + glob + "$").MatchString(name), nil
+}
+
+// PollIntervalOrDefault returns the configured poll interval or the given default
+func (pe *PreviewEnvironment) PollIntervalOrDefault(defaultInterval time.Duration) time.Duration {
+	if pe.Spec.PollInterval == nil {
+		return defaultInterval
+	}
+	return pe.Spec.PollInterval.Duration
+}
+
+// PreviousCommitsOrDefault returns how many previously deployed commits of an instance keep running
+func (pe *PreviewEnvironment) PreviousCommitsOrDefault() int {
+	if pe.Spec.ApplicationSettings.PreviousCommits == nil {
+		return DefaultPreviousCommits
+	}
+	return *pe.Spec.ApplicationSettings.PreviousCommits
+}
+
+// CommentTemplate returns the configured template of the pull request comment, empty if the default should be used
+func (pe *PreviewEnvironment) CommentTemplate() string {
+	if pe.Spec.CommentTemplate == nil {
+		return ""
+	}
+	return *pe.Spec.CommentTemplate
+}
+
+// HasAccess checks if the user with the given username is one of the users of the access settings
+func (pe *PreviewEnvironment) HasAccess(username string) bool {
+	for _, u := range pe.Spec.AccessSettings.Users {
+		if strings.EqualFold(u.Username, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiryAction returns the configured action for expired instances
+func (pe *PreviewEnvironment) ExpiryAction() string {
+	if pe.Spec.ExpiryPolicy == nil || pe.Spec.ExpiryPolicy.Action == "" {
+		return StaleActionDelete
+	}
+	return pe.Spec.ExpiryPolicy.Action
+}
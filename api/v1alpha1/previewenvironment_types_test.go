@@ -0,0 +1,82 @@
+package v1alpha1
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		value    string
+		expected bool
+		err      bool
+	}{
+		{name: "exact glob", pattern: "main", value: "main", expected: true},
+		{name: "glob matches the whole name", pattern: "main", value: "main-2"},
+		{name: "star matches slashes", pattern: "feature/*", value: "feature/api/login", expected: true},
+		{name: "star matches nothing", pattern: "release-*", value: "release-", expected: true},
+		{name: "question mark matches one character", pattern: "v?", value: "v1", expected: true},
+		{name: "question mark does not match two characters", pattern: "v?", value: "v10"},
+		{name: "regex characters in globs are literal", pattern: "release.1", value: "release-1"},
+		{name: "brackets in globs are literal", pattern: "[abc]", value: "[abc]", expected: true},
+		{name: "regex", pattern: "/^release-[0-9]+$/", value: "release-12", expected: true},
+		{name: "regex does not match", pattern: "/^release-[0-9]+$/", value: "release-next"},
+		{name: "regex is not anchored", pattern: "/release/", value: "pre-release-1", expected: true},
+		{name: "invalid regex", pattern: "/[/", value: "main", err: true},
+		{name: "single slash is a glob", pattern: "/", value: "/", expected: true},
+		{name: "two slashes are a glob", pattern: "//", value: "//", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := matchPattern(tt.pattern, tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("matchPattern() error = %v, expected error %v", err, tt.err)
+			}
+			if actual != tt.expected {
+				t.Errorf("matchPattern(%q, %q) = %v, expected %v", tt.pattern, tt.value, actual, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatternFilterMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *PatternFilter
+		value    string
+		expected bool
+		err      bool
+	}{
+		{name: "nil filter selects everything", value: "main", expected: true},
+		{name: "empty filter selects everything", filter: &PatternFilter{}, value: "main", expected: true},
+		{name: "included", filter: &PatternFilter{Include: []string{"main", "feature/*"}}, value: "feature/login", expected: true},
+		{name: "not included", filter: &PatternFilter{Include: []string{"main", "feature/*"}}, value: "fix/login"},
+		{name: "excluded", filter: &PatternFilter{Exclude: []string{"dependabot/*"}}, value: "dependabot/npm/lodash"},
+		{name: "not excluded", filter: &PatternFilter{Exclude: []string{"dependabot/*"}}, value: "main", expected: true},
+		{
+			name:   "exclude takes precedence over include",
+			filter: &PatternFilter{Include: []string{"feature/*"}, Exclude: []string{"/-wip$/"}},
+			value:  "feature/login-wip",
+		},
+		{
+			name:     "glob and regex includes",
+			filter:   &PatternFilter{Include: []string{"main", "/^release-[0-9]+$/"}},
+			value:    "release-3",
+			expected: true,
+		},
+		{name: "invalid exclude", filter: &PatternFilter{Exclude: []string{"/(/"}}, value: "main", err: true},
+		{name: "invalid include", filter: &PatternFilter{Include: []string{"/(/"}}, value: "main", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.filter.Matches(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("Matches() error = %v, expected error %v", err, tt.err)
+			}
+			if actual != tt.expected {
+				t.Errorf("Matches(%q) = %v, expected %v", tt.value, actual, tt.expected)
+			}
+		})
+	}
+}
@@ -17,9 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -33,6 +37,15 @@ type PreviewEnvironmentInstanceSpec struct {
 	// +kubebuilder:validation:Required
 	// DesiredPhase the desired phase of the preview environment instance
 	DesiredPhase string `json:"desiredPhase"`
+
+	// +optional
+	// ExtendedUntil the instance does not expire before this time, set when a reviewer extends the instance
+	ExtendedUntil *metav1.Time `json:"extendedUntil,omitempty"`
+
+	// +optional
+	// ApprovedCommitHash the commit of a fork pull request a maintainer approved to be built
+	// every new commit of a fork has to be approved again
+	ApprovedCommitHash string `json:"approvedCommitHash,omitempty"`
 }
 
 type InstanceGitSettings struct {
@@ -47,6 +60,10 @@ type InstanceGitSettings struct {
 	// +optional
 	// CommitHash the last commit hash, this should be the version that the instance is running
 	CommitHash string `json:"commitHash"`
+
+	// +optional
+	// Fork is true if the pull request comes from another repository, its builds have to be approved by a maintainer
+	Fork bool `json:"fork,omitempty"`
 }
 
 // PreviewEnvironmentInstanceStatus defines the observed state of PreviewEnvironmentInstance.
@@ -61,8 +78,85 @@ type PreviewEnvironmentInstanceStatus struct {
 	BuiltVersions []BuiltVersion `json:"builtVersions"`
 
 	// +optional
-	// PublicFacingUrl the url where the preview environment can be accessed
+	// PublicFacingUrl the stable url where the preview environment can be accessed
+	// it does not contain the commit hash and always points to the newest healthy build
 	PublicFacingUrl string `json:"publicFacingUrl"`
+
+	// +optional
+	// CommitUrl the url of the currently deployed commit
+	CommitUrl string `json:"commitUrl"`
+
+	// +optional
+	// PreviousCommits the previously deployed commits that still run next to the current one, newest first
+	PreviousCommits []PreviousCommit `json:"previousCommits,omitempty"`
+
+	// +optional
+	// Build information about the latest build of the instance
+	Build *BuildStatus `json:"build,omitempty"`
+
+	// +optional
+	// Activity the last observed traffic of the instance, only tracked if idle scale down is enabled
+	Activity *ActivityStatus `json:"activity,omitempty"`
+
+	// +optional
+	// ExpiresAt when the instance expires based on the expiry policy of the preview environment
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// +optional
+	// ObservedGeneration the generation of the instance that was last processed by the controller
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// Conditions the latest observations of the state of the instance
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+type BuildStatus struct {
+	// +optional
+	// JobName the name of the job that builds the container image
+	JobName string `json:"jobName"`
+
+	// +optional
+	// CommitHash the commit hash that is being built
+	CommitHash string `json:"commitHash"`
+
+	// +optional
+	// StartTime the time the build was started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	// CompletionTime the time the build finished, either successful or failed
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// +optional
+	// Duration how long the build took
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// +optional
+	// FailureReason why the build failed, empty if the build did not fail
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+type ActivityStatus struct {
+	// +optional
+	// LastActivityTime the last time a request to the instance was observed
+	LastActivityTime *metav1.Time `json:"lastActivityTime,omitempty"`
+
+	// +optional
+	// RequestCount the request counter of the auth proxy at the last check
+	RequestCount int64 `json:"requestCount,omitempty"`
+}
+
+type PreviousCommit struct {
+	// +kubebuilder:validation:Required
+	// CommitHash the commit that is still running
+	CommitHash string `json:"commitHash"`
+
+	// +optional
+	// Url the url of the commit
+	Url string `json:"url,omitempty"`
 }
 
 type BuiltVersion struct {
@@ -82,10 +176,45 @@ const (
 	InstancePhaseRunning   = "running"
 	InstancePhaseFailed    = "failed"
 	InstancePhaseStopped   = "stopped"
+	InstancePhaseIdle      = "idle"
+
+	// InstancePhaseAwaitingApproval the commit of a fork pull request is not built before a maintainer approved it
+	InstancePhaseAwaitingApproval = "awaiting-approval"
+)
+
+const (
+	// WakeRequestedAnnotation is set on idle instances once a request for them came in
+	WakeRequestedAnnotation = "coflnet.com/wake-requested"
+
+	// RebuildRequestedAnnotation is set on instances whose current commit should be built and deployed again
+	RebuildRequestedAnnotation = "coflnet.com/rebuild-requested"
+)
+
+// DefaultExtension how long an instance is extended if no duration is given
+// starting an expired instance extends it by this duration as well
+const DefaultExtension = 24 * time.Hour
+
+const (
+	// ConditionBuildSucceeded is true if the container image for the current commit was built
+	ConditionBuildSucceeded = "BuildSucceeded"
+
+	// ConditionDeployed is true if all kubernetes resources of the instance were applied
+	ConditionDeployed = "Deployed"
+
+	// ConditionReady is true if the instance can serve requests
+	ConditionReady = "Ready"
+
+	// ConditionAuthConfigured is true if the authentication for the instance is set up
+	ConditionAuthConfigured = "AuthConfigured"
 )
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Url",type=string,JSONPath=".status.publicFacingUrl"
+// +kubebuilder:printcolumn:name="Expires",type=string,JSONPath=".status.expiresAt"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".metadata.creationTimestamp"
 
 // PreviewEnvironmentInstance is the Schema for the previewenvironmentinstances API.
 type PreviewEnvironmentInstance struct {
@@ -130,8 +259,21 @@ func PreviewEnvironmentInstanceContainerName(pe *PreviewEnvironment, identifier,
 	return fmt.Sprintf("%s/%s/tmpenv:%s-%s-%s-%s-%s", pe.Spec.ContainerRegistry.Registry, pe.Spec.ContainerRegistry.Repository, pe.GetOwner(), pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, identifier, commitHash)
 }
 
+// PreviewEnvironmentHttpPath returns the path of the current commit of the instance
 func PreviewEnvironmentHttpPath(pe *PreviewEnvironment, pei *PreviewEnvironmentInstance) string {
-	return fmt.Sprintf("/%s/%s/%s/%s", pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, pei.BranchOrPullRequestIdentifier(), pei.Spec.InstanceGitSettings.CommitHash)
+	return PreviewEnvironmentCommitHttpPath(pe, pei, pei.Spec.InstanceGitSettings.CommitHash)
+}
+
+// PreviewEnvironmentCommitHttpPath returns the path of a specific commit of the instance
+func PreviewEnvironmentCommitHttpPath(pe *PreviewEnvironment, pei *PreviewEnvironmentInstance, commitHash string) string {
+	return fmt.Sprintf("%s/%s", PreviewEnvironmentStableHttpPath(pe, pei), commitHash)
+}
+
+// PreviewEnvironmentStableHttpPath returns the path of the instance without the commit hash
+// the path stays the same for every push to the branch or pull request
+// branch names can contain slashes, the safe identifier keeps the path at one segment per part
+func PreviewEnvironmentStableHttpPath(pe *PreviewEnvironment, pei *PreviewEnvironmentInstance) string {
+	return fmt.Sprintf("/%s/%s/%s", pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, pei.SafeIdentifier())
 }
 
 func (g *InstanceGitSettings) BranchOrPullRequestIdentifier() string {
@@ -148,6 +290,53 @@ func (pei *PreviewEnvironmentInstance) BranchOrPullRequestIdentifier() string {
 	return pei.Spec.InstanceGitSettings.BranchOrPullRequestIdentifier()
 }
 
+// maxSafeIdentifierLength labels allow at most 63 characters
+const maxSafeIdentifierLength = 63
+
+var unsafeIdentifierCharacters = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// SafeIdentifier turns a branch name or pull request number into a value that can be used in labels and image tags
+// branch names can contain slashes and be longer than a label, those get a hash suffix to stay unique
+func SafeIdentifier(identifier string) string {
+	safe := strings.Trim(unsafeIdentifierCharacters.ReplaceAllString(identifier, "-"), "-_.")
+	if safe == identifier && len(safe) <= maxSafeIdentifierLength {
+		return safe
+	}
+
+	hash := sha256.Sum256([]byte(identifier))
+	suffix := hex.EncodeToString(hash[:])[:8]
+	if len(safe) > maxSafeIdentifierLength-len(suffix)-1 {
+		safe = strings.TrimRight(safe[:maxSafeIdentifierLength-len(suffix)-1], "-_.")
+	}
+	if safe == "" {
+		return suffix
+	}
+	return safe + "-" + suffix
+}
+
+// SafeIdentifier returns the identifier of the instance that can be used in labels and image tags
+func (pei *PreviewEnvironmentInstance) SafeIdentifier() string {
+	return SafeIdentifier(pei.BranchOrPullRequestIdentifier())
+}
+
+// NeedsApproval checks if the current commit of the instance comes from a fork and was not approved yet
+func (pei *PreviewEnvironmentInstance) NeedsApproval() bool {
+	return pei.Spec.InstanceGitSettings.Fork && pei.Spec.ApprovedCommitHash != pei.Spec.InstanceGitSettings.CommitHash
+}
+
+// MinReviewedCommitLength the shortest abbreviation of a commit that is accepted to approve a build
+const MinReviewedCommitLength = 7
+
+// IsReviewedCommit checks if the current commit of the instance is the commit a maintainer reviewed
+// the reviewed commit can be abbreviated like git does it, but not shorter than MinReviewedCommitLength
+func (pei *PreviewEnvironmentInstance) IsReviewedCommit(reviewed string) bool {
+	commit := pei.Spec.InstanceGitSettings.CommitHash
+	if commit == "" || len(reviewed) < MinReviewedCommitLength {
+		return false
+	}
+	return strings.HasPrefix(commit, strings.ToLower(reviewed))
+}
+
 func (pei *PreviewEnvironmentInstance) GetOwner() string {
 	return pei.GetLabels()["owner"]
 }
@@ -159,3 +348,21 @@ func (pei *PreviewEnvironmentInstance) GetPreviewEnvironmentId() string {
 func (pei *PreviewEnvironmentInstance) NameForAuthProxy() string {
 	return fmt.Sprintf("%s-auth-proxy", pei.GetName())
 }
+
+// NameForGitCredentials returns the name of the secret the build uses to clone the repository
+func (pei *PreviewEnvironmentInstance) NameForGitCredentials() string {
+	return fmt.Sprintf("%s-git-credentials", pei.GetName())
+}
+
+// NameForCommit returns the name of the deployment and the service that keep a previous commit of the instance running
+func (pei *PreviewEnvironmentInstance) NameForCommit(commitHash string) string {
+	if len(commitHash) > 7 {
+		commitHash = commitHash[:7]
+	}
+	return fmt.Sprintf("%s-%s", pei.GetName(), commitHash)
+}
+
+// NameForWakeService returns the name of the service that forwards requests of an idle instance to the operator
+func (pei *PreviewEnvironmentInstance) NameForWakeService() string {
+	return fmt.Sprintf("%s-wake", pei.GetName())
+}
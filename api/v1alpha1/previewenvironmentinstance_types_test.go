@@ -0,0 +1,49 @@
+package v1alpha1
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSafeIdentifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		identifier string
+		expected   string
+	}{
+		{name: "pull request number", identifier: "42", expected: "42"},
+		{name: "safe branch", identifier: "main", expected: "main"},
+		{name: "safe characters are kept", identifier: "Feature_X.1", expected: "Feature_X.1"},
+		{name: "slashes get a hash suffix", identifier: "feature/login", expected: "feature-login-df7c7aeb"},
+		{name: "leading and trailing separators are trimmed", identifier: "-main-", expected: "main-ee581b90"},
+		{name: "trailing dot is trimmed", identifier: "release/1.0.", expected: "release-1.0-087fc2e4"},
+		{name: "only unsafe characters", identifier: "///", expected: "732c4e97"},
+		{name: "non ascii characters", identifier: "ü", expected: "607474ca"},
+		{name: "longest label is kept", identifier: strings.Repeat("a", 63), expected: strings.Repeat("a", 63)},
+		{name: "too long", identifier: strings.Repeat("a", 64), expected: strings.Repeat("a", 54) + "-ffe054fe"},
+		{
+			name:       "too long with unsafe characters",
+			identifier: "feature/" + strings.Repeat("b", 70),
+			expected:   "feature-" + strings.Repeat("b", 46) + "-3ebd2d83",
+		},
+	}
+
+	label := regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]*[A-Za-z0-9])?$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := SafeIdentifier(tt.identifier)
+			if actual != tt.expected {
+				t.Errorf("SafeIdentifier(%q) = %q, expected %q", tt.identifier, actual, tt.expected)
+			}
+			if len(actual) > maxSafeIdentifierLength || !label.MatchString(actual) {
+				t.Errorf("SafeIdentifier(%q) = %q is not a valid label value", tt.identifier, actual)
+			}
+		})
+	}
+
+	// branches that only differ in unsafe characters must not share an identifier
+	if SafeIdentifier("feature/login") == SafeIdentifier("feature-login") {
+		t.Errorf("feature/login and feature-login have the same identifier %q", SafeIdentifier("feature-login"))
+	}
+}
@@ -21,6 +21,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -44,6 +45,25 @@ func (in *AccessSettings) DeepCopy() *AccessSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActivityStatus) DeepCopyInto(out *ActivityStatus) {
+	*out = *in
+	if in.LastActivityTime != nil {
+		in, out := &in.LastActivityTime, &out.LastActivityTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActivityStatus.
+func (in *ActivityStatus) DeepCopy() *ActivityStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActivityStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ApplicationSettings) DeepCopyInto(out *ApplicationSettings) {
 	*out = *in
@@ -61,6 +81,21 @@ func (in *ApplicationSettings) DeepCopyInto(out *ApplicationSettings) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.IdleTimeout != nil {
+		in, out := &in.IdleTimeout, &out.IdleTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.PreviousCommits != nil {
+		in, out := &in.PreviousCommits, &out.PreviousCommits
+		*out = new(int)
+		**out = **in
+	}
+	if in.HealthCheckPath != nil {
+		in, out := &in.HealthCheckPath, &out.HealthCheckPath
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationSettings.
@@ -73,6 +108,21 @@ func (in *ApplicationSettings) DeepCopy() *ApplicationSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildArg) DeepCopyInto(out *BuildArg) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildArg.
+func (in *BuildArg) DeepCopy() *BuildArg {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildSettings) DeepCopyInto(out *BuildSettings) {
 	*out = *in
@@ -81,11 +131,56 @@ func (in *BuildSettings) DeepCopyInto(out *BuildSettings) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.BranchFilter != nil {
+		in, out := &in.BranchFilter, &out.BranchFilter
+		*out = new(PatternFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PullRequestHeadFilter != nil {
+		in, out := &in.PullRequestHeadFilter, &out.PullRequestHeadFilter
+		*out = new(PatternFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PullRequestBaseFilter != nil {
+		in, out := &in.PullRequestBaseFilter, &out.PullRequestBaseFilter
+		*out = new(PatternFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PullRequestLabel != nil {
+		in, out := &in.PullRequestLabel, &out.PullRequestLabel
+		*out = new(string)
+		**out = **in
+	}
+	if in.PullRequestAuthorFilter != nil {
+		in, out := &in.PullRequestAuthorFilter, &out.PullRequestAuthorFilter
+		*out = new(PatternFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ForkApprovalLabel != nil {
+		in, out := &in.ForkApprovalLabel, &out.ForkApprovalLabel
+		*out = new(string)
+		**out = **in
+	}
 	if in.DockerfilePath != nil {
 		in, out := &in.DockerfilePath, &out.DockerfilePath
 		*out = new(string)
 		**out = **in
 	}
+	if in.ContextPath != nil {
+		in, out := &in.ContextPath, &out.ContextPath
+		*out = new(string)
+		**out = **in
+	}
+	if in.BuildArgs != nil {
+		in, out := &in.BuildArgs, &out.BuildArgs
+		*out = make([]BuildArg, len(*in))
+		copy(*out, *in)
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildSettings.
@@ -98,6 +193,34 @@ func (in *BuildSettings) DeepCopy() *BuildSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildStatus) DeepCopyInto(out *BuildStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildStatus.
+func (in *BuildStatus) DeepCopy() *BuildStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuiltVersion) DeepCopyInto(out *BuiltVersion) {
 	*out = *in
@@ -114,6 +237,26 @@ func (in *BuiltVersion) DeepCopy() *BuiltVersion {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CleanupSettings) DeepCopyInto(out *CleanupSettings) {
+	*out = *in
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CleanupSettings.
+func (in *CleanupSettings) DeepCopy() *CleanupSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(CleanupSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerRegistry) DeepCopyInto(out *ContainerRegistry) {
 	*out = *in
@@ -144,6 +287,36 @@ func (in *EnvironmentVariable) DeepCopy() *EnvironmentVariable {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExpiryPolicy) DeepCopyInto(out *ExpiryPolicy) {
+	*out = *in
+	if in.MaxAge != nil {
+		in, out := &in.MaxAge, &out.MaxAge
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.IdleTimeout != nil {
+		in, out := &in.IdleTimeout, &out.IdleTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ExpireAfterPRClosed != nil {
+		in, out := &in.ExpireAfterPRClosed, &out.ExpireAfterPRClosed
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExpiryPolicy.
+func (in *ExpiryPolicy) DeepCopy() *ExpiryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ExpiryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GitSettings) DeepCopyInto(out *GitSettings) {
 	*out = *in
@@ -184,6 +357,31 @@ func (in *InstanceGitSettings) DeepCopy() *InstanceGitSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PatternFilter) DeepCopyInto(out *PatternFilter) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PatternFilter.
+func (in *PatternFilter) DeepCopy() *PatternFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(PatternFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PreviewEnvironment) DeepCopyInto(out *PreviewEnvironment) {
 	*out = *in
@@ -274,6 +472,10 @@ func (in *PreviewEnvironmentInstanceList) DeepCopyObject() runtime.Object {
 func (in *PreviewEnvironmentInstanceSpec) DeepCopyInto(out *PreviewEnvironmentInstanceSpec) {
 	*out = *in
 	in.InstanceGitSettings.DeepCopyInto(&out.InstanceGitSettings)
+	if in.ExtendedUntil != nil {
+		in, out := &in.ExtendedUntil, &out.ExtendedUntil
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewEnvironmentInstanceSpec.
@@ -296,6 +498,32 @@ func (in *PreviewEnvironmentInstanceStatus) DeepCopyInto(out *PreviewEnvironment
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.PreviousCommits != nil {
+		in, out := &in.PreviousCommits, &out.PreviousCommits
+		*out = make([]PreviousCommit, len(*in))
+		copy(*out, *in)
+	}
+	if in.Build != nil {
+		in, out := &in.Build, &out.Build
+		*out = new(BuildStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Activity != nil {
+		in, out := &in.Activity, &out.Activity
+		*out = new(ActivityStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExpiresAt != nil {
+		in, out := &in.ExpiresAt, &out.ExpiresAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewEnvironmentInstanceStatus.
@@ -352,6 +580,26 @@ func (in *PreviewEnvironmentSpec) DeepCopyInto(out *PreviewEnvironmentSpec) {
 	in.ApplicationSettings.DeepCopyInto(&out.ApplicationSettings)
 	in.BuildSettings.DeepCopyInto(&out.BuildSettings)
 	in.AccessSettings.DeepCopyInto(&out.AccessSettings)
+	if in.CleanupSettings != nil {
+		in, out := &in.CleanupSettings, &out.CleanupSettings
+		*out = new(CleanupSettings)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExpiryPolicy != nil {
+		in, out := &in.ExpiryPolicy, &out.ExpiryPolicy
+		*out = new(ExpiryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PollInterval != nil {
+		in, out := &in.PollInterval, &out.PollInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.CommentTemplate != nil {
+		in, out := &in.CommentTemplate, &out.CommentTemplate
+		*out = new(string)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewEnvironmentSpec.
@@ -372,6 +620,13 @@ func (in *PreviewEnvironmentStatus) DeepCopyInto(out *PreviewEnvironmentStatus)
 		*out = make([]int, len(*in))
 		copy(*out, *in)
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviewEnvironmentStatus.
@@ -384,6 +639,21 @@ func (in *PreviewEnvironmentStatus) DeepCopy() *PreviewEnvironmentStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PreviousCommit) DeepCopyInto(out *PreviousCommit) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PreviousCommit.
+func (in *PreviousCommit) DeepCopy() *PreviousCommit {
+	if in == nil {
+		return nil
+	}
+	out := new(PreviousCommit)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UserAccess) DeepCopyInto(out *UserAccess) {
 	*out = *in
@@ -21,6 +21,7 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -29,6 +30,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -39,6 +41,7 @@ import (
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
 	"github.com/coflnet/pr-env/internal/controller"
+	"github.com/coflnet/pr-env/internal/events"
 	"github.com/coflnet/pr-env/internal/git"
 	"github.com/coflnet/pr-env/internal/keycloak"
 	"github.com/coflnet/pr-env/internal/kubeclient"
@@ -64,6 +67,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var defaultPollInterval time.Duration
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -75,6 +79,9 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.DurationVar(&defaultPollInterval, "default-poll-interval", 5*time.Minute,
+		"How often repositories are checked for new pull requests and branches if the preview environment "+
+			"does not configure a poll interval. Use 0 to only rely on webhooks.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -93,6 +100,10 @@ func main() {
 		setupLog.Error(err, "unable to create github client")
 		os.Exit(1)
 	}
+	gitProviders := git.NewProviders(ctrl.Log.WithName("git"), gc)
+
+	// the http server forwards webhook events to the controllers
+	eventBus := events.NewBus(ctrl.Log.WithName("events"))
 
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
@@ -162,16 +173,18 @@ func main() {
 	}
 
 	if err = (&controller.PreviewEnvironmentReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, gc); err != nil {
+		Client:              mgr.GetClient(),
+		Scheme:              mgr.GetScheme(),
+		DefaultPollInterval: defaultPollInterval,
+	}).SetupWithManager(mgr, gitProviders, eventBus); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PreviewEnvironment")
 		os.Exit(1)
 	}
 	if err = (&controller.PreviewEnvironmentInstanceReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, gc, keycloakClient); err != nil {
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+	}).SetupWithManager(mgr, gitProviders, keycloakClient, eventBus); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PreviewEnvironmentInstance")
 		os.Exit(1)
 	}
@@ -190,7 +203,9 @@ func main() {
 	kubeClient := kubeclient.NewKubeClient(kubeLogger)
 
 	serverLogger := ctrl.Log.WithName("server")
-	server := server.NewServer(context.TODO(), &serverLogger, gc, kubeClient, keycloakClient)
+	// the wake handler is not part of the public api, its port is only exposed inside of the cluster
+	wakeServer := server.NewWakeServer(&serverLogger, kubeClient)
+	server := server.NewServer(context.TODO(), &serverLogger, gc, kubeClient, keycloakClient, eventBus)
 
 	go func() {
 		// TODO: read the port and listen address from the environment
@@ -200,6 +215,13 @@ func main() {
 		}
 	}()
 
+	go func() {
+		if err := wakeServer.Start("0.0.0.0:8082"); err != nil {
+			setupLog.Error(err, "unable to start wake server")
+			os.Exit(1)
+		}
+	}()
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
+)
+
+// fakeProvider serves the pull requests and branches of a single repository from memory
+// it records the commit statuses, those are the checks the controller reports
+type fakeProvider struct {
+	mu sync.Mutex
+
+	pullRequests []git.PullRequest
+	branches     []git.Branch
+	comments     []git.Comment
+	statuses     []git.CommitStatus
+}
+
+var _ git.Provider = &fakeProvider{}
+
+// fakeProviders returns the providers the reconcilers use, github repositories are served by the fake
+func fakeProviders(provider *fakeProvider) *git.Providers {
+	return git.NewStaticProviders(logr.Discard(), map[string]git.Provider{
+		coflnetv1alpha1.GitProviderGithub: provider,
+	})
+}
+
+func (p *fakeProvider) PullRequests(ctx context.Context, owner, repo string) ([]git.PullRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]git.PullRequest{}, p.pullRequests...), nil
+}
+
+func (p *fakeProvider) PullRequest(ctx context.Context, owner, repo string, number int) (*git.PullRequest, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pr := range p.pullRequests {
+		if pr.Number == number {
+			return &pr, nil
+		}
+	}
+	return nil, fmt.Errorf("pull request %d of %s/%s not found", number, owner, repo)
+}
+
+func (p *fakeProvider) Branches(ctx context.Context, owner, repo string) ([]git.Branch, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]git.Branch{}, p.branches...), nil
+}
+
+func (p *fakeProvider) BranchHeadSha(ctx context.Context, owner, repo, branch string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, b := range p.branches {
+		if b.Name == branch {
+			return b.HeadSha, nil
+		}
+	}
+	return "", fmt.Errorf("branch %s of %s/%s not found", branch, owner, repo)
+}
+
+func (p *fakeProvider) Comments(ctx context.Context, owner, repo string, number int) ([]git.Comment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]git.Comment{}, p.comments...), nil
+}
+
+func (p *fakeProvider) PostComment(ctx context.Context, owner, repo string, number int, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.comments = append(p.comments, git.Comment{ID: int64(len(p.comments) + 1), Body: message})
+	return nil
+}
+
+func (p *fakeProvider) UpdateComment(ctx context.Context, owner, repo string, number int, id int64, message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.comments {
+		if p.comments[i].ID == id {
+			p.comments[i].Body = message
+			return nil
+		}
+	}
+	return fmt.Errorf("comment %d not found", id)
+}
+
+func (p *fakeProvider) DeleteComment(ctx context.Context, owner, repo string, number int, id int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.comments {
+		if p.comments[i].ID == id {
+			p.comments = append(p.comments[:i], p.comments[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *fakeProvider) SetCommitStatus(ctx context.Context, owner, repo, sha string, status git.CommitStatus) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statuses = append(p.statuses, status)
+	return nil
+}
+
+func (p *fakeProvider) CloneUrl(owner, repo, branch, sha string) string {
+	return fmt.Sprintf("git://git.example.com/%s/%s.git#refs/heads/%s#%s", owner, repo, branch, sha)
+}
+
+func (p *fakeProvider) PullRequestCloneUrl(owner, repo string, number int, sha string) string {
+	return fmt.Sprintf("git://git.example.com/%s/%s.git#refs/pull/%d/head#%s", owner, repo, number, sha)
+}
+
+// CloneCredentials the fake repository is public
+func (p *fakeProvider) CloneCredentials(ctx context.Context, owner, repo string) (*git.Credentials, error) {
+	return nil, nil
+}
+
+// lastStatus returns the last commit status that was reported
+func (p *fakeProvider) lastStatus() git.CommitStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.statuses) == 0 {
+		return git.CommitStatus{}
+	}
+	return p.statuses[len(p.statuses)-1]
+}
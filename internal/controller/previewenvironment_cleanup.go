@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+const (
+	// staleSinceAnnotation is set on instances whose pull request or branch does not exist anymore
+	staleSinceAnnotation = "coflnet.com/stale-since"
+)
+
+// cleanupStaleInstances compares the existing instances of the preview environment with the instances that should exist
+// instances that are not needed anymore are deleted or stopped once the grace period is over
+// returns the time after which the next stale instance has to be processed, 0 if there is none
+func (r *PreviewEnvironmentReconciler) cleanupStaleInstances(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, desired []*coflnetv1alpha1.PreviewEnvironmentInstance) (time.Duration, error) {
+	var existing coflnetv1alpha1.PreviewEnvironmentInstanceList
+	if err := r.List(ctx, &existing, client.InNamespace(pe.Namespace), client.MatchingLabels{"previewenvironment": string(pe.GetUID())}); err != nil {
+		return 0, err
+	}
+
+	desiredNames := map[string]bool{}
+	for _, pei := range desired {
+		desiredNames[pei.GetName()] = true
+	}
+
+	var requeueAfter time.Duration
+	for i := range existing.Items {
+		pei := &existing.Items[i]
+		if desiredNames[pei.GetName()] || !pei.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		remaining, err := r.cleanupStaleInstance(ctx, pe, pei)
+		if err != nil {
+			return 0, err
+		}
+
+		if remaining > 0 && (requeueAfter == 0 || remaining < requeueAfter) {
+			requeueAfter = remaining
+		}
+	}
+
+	return requeueAfter, nil
+}
+
+// cleanupStaleInstance marks the instance as stale and applies the stale action after the grace period
+// returns the remaining grace period
+func (r *PreviewEnvironmentReconciler) cleanupStaleInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (time.Duration, error) {
+	staleSince, err := time.Parse(time.RFC3339, pei.GetAnnotations()[staleSinceAnnotation])
+	if err != nil {
+		r.log.Info("marking preview environment instance as stale", "namespace", pei.Namespace, "name", pei.Name)
+		staleSince = time.Now()
+
+		annotations := pei.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[staleSinceAnnotation] = staleSince.UTC().Format(time.RFC3339)
+		pei.SetAnnotations(annotations)
+
+		if err := r.Update(ctx, pei); err != nil {
+			return 0, err
+		}
+	}
+
+	remaining := time.Until(staleSince.Add(pe.StaleGracePeriod()))
+	if remaining > 0 {
+		return remaining, nil
+	}
+
+	switch pe.StaleAction() {
+	case coflnetv1alpha1.StaleActionStop:
+		if pei.Spec.DesiredPhase == coflnetv1alpha1.InstancePhaseStopped {
+			return 0, nil
+		}
+
+		r.log.Info("stopping stale preview environment instance", "namespace", pei.Namespace, "name", pei.Name)
+		r.postCleanupMessage(ctx, pe, pei)
+		pei.Spec.DesiredPhase = coflnetv1alpha1.InstancePhaseStopped
+		return 0, r.Update(ctx, pei)
+	default:
+		r.log.Info("deleting stale preview environment instance", "namespace", pei.Namespace, "name", pei.Name)
+		r.postCleanupMessage(ctx, pe, pei)
+		return 0, client.IgnoreNotFound(r.Delete(ctx, pei))
+	}
+}
+
+// postCleanupMessage tells the pull request that the preview is gone
+// failing to post the message should not block the cleanup, the pull request might not exist anymore
+func (r *PreviewEnvironmentReconciler) postCleanupMessage(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) {
+	if pei.Spec.InstanceGitSettings.PullRequestNumber == nil {
+		return
+	}
+
+	if err := r.gitProviders.PostPullRequestCleanupMessage(ctx, pe, pei, pe.StaleAction()); err != nil {
+		r.log.Error(err, "unable to post the cleanup message to the pull request", "namespace", pei.Namespace, "name", pei.Name)
+	}
+}
@@ -18,18 +18,25 @@ package controller
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
-	"github.com/google/go-github/v66/github"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/events"
 	"github.com/coflnet/pr-env/internal/git"
 	"github.com/go-logr/logr"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -37,12 +44,19 @@ import (
 
 const finalizerName = "coflnet.com.pr.env/finalizer"
 
+// pollJitterFactor spreads the polls of the repositories up to 10% over the poll interval
+const pollJitterFactor = 0.1
+
 // PreviewEnvironmentReconciler reconciles a PreviewEnvironment object
 type PreviewEnvironmentReconciler struct {
 	client.Client
 	Scheme       *runtime.Scheme
-	githubClient *git.GithubClient
+	gitProviders *git.Providers
 	log          logr.Logger
+
+	// DefaultPollInterval how often repositories are checked if the preview environment does not configure it
+	// polling is disabled if it is 0
+	DefaultPollInterval time.Duration
 }
 
 // +kubebuilder:rbac:groups=coflnet.coflnet.com,resources=previewenvironments,verbs=get;list;watch;create;update;patch;delete
@@ -50,7 +64,6 @@ type PreviewEnvironmentReconciler struct {
 // +kubebuilder:rbac:groups=coflnet.coflnet.com,resources=previewenvironments/finalizers,verbs=update
 func (r *PreviewEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	r.log.Info("Reconciling PreviewEnvironment", "namespace", req.Namespace, "name", req.Name)
-	// TODO: mark environment as updating
 
 	// load the preview environment
 	var pe coflnetv1alpha1.PreviewEnvironment
@@ -92,8 +105,10 @@ func (r *PreviewEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.R
 	// list all the instances that should be created
 	peis, err := r.detectInstancesThatShouldBeCreated(ctx, pe)
 	if err != nil {
-		// TODO: mark the pe as failed
 		r.log.Error(err, "Unable to detect instances that should be created", "namespace", req.Namespace, "name", req.Name)
+		if err := r.updatePreviewEnvironmentStatus(ctx, &pe, coflnetv1alpha1.PreviewEnvironmentPhaseError, metav1.ConditionFalse, "DetectionFailed", err.Error(), pe.Status.PullRequestsDetected); err != nil {
+			r.log.Error(err, "Unable to update the status of the PreviewEnvironment", "namespace", req.Namespace, "name", req.Name)
+		}
 		return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
 	}
 
@@ -101,14 +116,84 @@ func (r *PreviewEnvironmentReconciler) Reconcile(ctx context.Context, req ctrl.R
 	err = r.savePreviewEnvironmentInstances(ctx, &pe, peis)
 	if err != nil {
 		r.log.Error(err, "Unable to create PreviewEnvironment instances", "namespace", req.Namespace, "name", req.Name)
+		if err := r.updatePreviewEnvironmentStatus(ctx, &pe, coflnetv1alpha1.PreviewEnvironmentPhaseError, metav1.ConditionFalse, "SyncFailed", err.Error(), pullRequestNumbers(peis)); err != nil {
+			r.log.Error(err, "Unable to update the status of the PreviewEnvironment", "namespace", req.Namespace, "name", req.Name)
+		}
+		return ctrl.Result{
+			RequeueAfter: time.Minute * 1,
+		}, nil
+	}
+
+	// instances of closed pull requests and deleted branches are not needed anymore
+	requeueAfter, err := r.cleanupStaleInstances(ctx, &pe, peis)
+	if err != nil {
+		r.log.Error(err, "Unable to clean up stale PreviewEnvironment instances", "namespace", req.Namespace, "name", req.Name)
+		if err := r.updatePreviewEnvironmentStatus(ctx, &pe, coflnetv1alpha1.PreviewEnvironmentPhaseError, metav1.ConditionFalse, "CleanupFailed", err.Error(), pullRequestNumbers(peis)); err != nil {
+			r.log.Error(err, "Unable to update the status of the PreviewEnvironment", "namespace", req.Namespace, "name", req.Name)
+		}
 		return ctrl.Result{
 			RequeueAfter: time.Minute * 1,
 		}, nil
 	}
 
-	// TODO: update status
+	message := fmt.Sprintf("%d instances are synced with the repository", len(peis))
+	if err := r.updatePreviewEnvironmentStatus(ctx, &pe, coflnetv1alpha1.PreviewEnvironmentPhaseReady, metav1.ConditionTrue, "InstancesSynced", message, pullRequestNumbers(peis)); err != nil {
+		r.log.Error(err, "Unable to update the status of the PreviewEnvironment", "namespace", req.Namespace, "name", req.Name)
+		return ctrl.Result{}, err
+	}
+
+	// poll the repository again, webhooks are not configured for every repository
+	if pollAfter := r.nextPoll(&pe); pollAfter > 0 && (requeueAfter == 0 || pollAfter < requeueAfter) {
+		requeueAfter = pollAfter
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// nextPoll returns when the repository of the preview environment should be checked again, 0 if polling is disabled
+// the interval is jittered so environments created at the same time do not hit the git api at the same moment
+func (r *PreviewEnvironmentReconciler) nextPoll(pe *coflnetv1alpha1.PreviewEnvironment) time.Duration {
+	interval := pe.PollIntervalOrDefault(r.DefaultPollInterval)
+	if interval <= 0 {
+		return 0
+	}
+	return wait.Jitter(interval, pollJitterFactor)
+}
+
+// updatePreviewEnvironmentStatus sets the phase and the ready condition of the preview environment
+// the status is only written if something changed, otherwise every reconcile would trigger a new one
+func (r *PreviewEnvironmentReconciler) updatePreviewEnvironmentStatus(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, phase string, status metav1.ConditionStatus, reason, message string, pullRequests []int) error {
+	updated := pe.DeepCopy()
+	updated.Status.Phase = phase
+	updated.Status.PullRequestsDetected = pullRequests
+	updated.Status.ObservedGeneration = pe.GetGeneration()
+	meta.SetStatusCondition(&updated.Status.Conditions, metav1.Condition{
+		Type:               coflnetv1alpha1.ConditionReady,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pe.GetGeneration(),
+	})
+
+	if equality.Semantic.DeepEqual(pe.Status, updated.Status) {
+		return nil
+	}
+
+	if err := r.Status().Update(ctx, updated); err != nil {
+		return err
+	}
+	pe.Status = updated.Status
+	return nil
+}
+
+func pullRequestNumbers(peis []*coflnetv1alpha1.PreviewEnvironmentInstance) []int {
+	result := []int{}
+	for _, pei := range peis {
+		if pei.Spec.InstanceGitSettings.PullRequestNumber != nil {
+			result = append(result, *pei.Spec.InstanceGitSettings.PullRequestNumber)
+		}
+	}
+	return result
 }
 
 func (r *PreviewEnvironmentReconciler) detectInstancesThatShouldBeCreated(ctx context.Context, pe coflnetv1alpha1.PreviewEnvironment) ([]*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
@@ -138,47 +223,110 @@ func (r *PreviewEnvironmentReconciler) detectInstancesThatShouldBeCreated(ctx co
 	return result, nil
 }
 
-func (r *PreviewEnvironmentReconciler) detectOpenPullRequests(ctx context.Context, pr coflnetv1alpha1.PreviewEnvironment) ([]*github.PullRequest, error) {
-	prs, err := r.githubClient.PullRequestsOfRepository(ctx, pr.Spec.GitSettings.Organization, pr.Spec.GitSettings.Repository)
-	return prs, err
+func (r *PreviewEnvironmentReconciler) detectOpenPullRequests(ctx context.Context, pr coflnetv1alpha1.PreviewEnvironment) ([]git.PullRequest, error) {
+	if !pr.Spec.BuildSettings.BuildsPullRequests() {
+		return []git.PullRequest{}, nil
+	}
+
+	provider, err := r.gitProviders.ForEnvironment(ctx, &pr)
+	if err != nil {
+		return nil, err
+	}
+
+	pullRequests, err := provider.PullRequests(ctx, pr.Spec.GitSettings.Organization, pr.Spec.GitSettings.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []git.PullRequest
+	for _, pullRequest := range pullRequests {
+		selected, err := pullRequestSelected(&pr.Spec.BuildSettings, pullRequest)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			filtered = append(filtered, pullRequest)
+		}
+	}
+	return filtered, nil
+}
+
+// pullRequestSelected checks the pull request against the label, draft, author and branch filters of the build settings
+func pullRequestSelected(buildSettings *coflnetv1alpha1.BuildSettings, pullRequest git.PullRequest) (bool, error) {
+	if !buildSettings.HasPullRequestLabel(pullRequest.Labels) {
+		return false, nil
+	}
+	if buildSettings.SkipDraftPullRequests && pullRequest.Draft {
+		return false, nil
+	}
+
+	for _, check := range []struct {
+		filter *coflnetv1alpha1.PatternFilter
+		value  string
+	}{
+		{buildSettings.PullRequestAuthorFilter, pullRequest.Author},
+		{buildSettings.PullRequestHeadFilter, pullRequest.HeadBranch},
+		{buildSettings.PullRequestBaseFilter, pullRequest.BaseBranch},
+	} {
+		match, err := check.filter.Matches(check.value)
+		if err != nil || !match {
+			return false, err
+		}
+	}
+	return true, nil
 }
 
 // detectOpenBranches detects the open branches of the repository
 // returns the name of the branches as a string slice
 func (r *PreviewEnvironmentReconciler) detectOpenBranches(ctx context.Context, pr coflnetv1alpha1.PreviewEnvironment) ([]string, error) {
-	if pr.Spec.BuildSettings.BuildAllBranches == false {
+	buildSettings := pr.Spec.BuildSettings
+	if !buildSettings.BuildAllBranches && buildSettings.BranchWildcard == nil && !buildSettings.BranchFilter.HasInclude() {
 		return []string{}, nil
 	}
 
-	branches, err := r.githubClient.BranchesOfRepository(ctx, pr.Spec.GitSettings.Organization, pr.Spec.GitSettings.Repository)
+	provider, err := r.gitProviders.ForEnvironment(ctx, &pr)
+	if err != nil {
+		return nil, err
+	}
 
-	if pr.Spec.BuildSettings.BuildAllBranches || pr.Spec.BuildSettings.BranchWildcard == nil {
-		return branches, err
+	branches, err := provider.Branches(ctx, pr.Spec.GitSettings.Organization, pr.Spec.GitSettings.Repository)
+	if err != nil {
+		return nil, err
 	}
 
-	var filteredBranches []string
+	var branchNames []string
 	for _, branch := range branches {
-		if strings.Contains(branch, *pr.Spec.BuildSettings.BranchWildcard) {
-			filteredBranches = append(filteredBranches, branch)
+		// the legacy wildcard only applies if not all branches should be built
+		if !buildSettings.BuildAllBranches && buildSettings.BranchWildcard != nil && !strings.Contains(branch.Name, *buildSettings.BranchWildcard) {
+			continue
+		}
+
+		match, err := buildSettings.BranchFilter.Matches(branch.Name)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			branchNames = append(branchNames, branch.Name)
 		}
 	}
-	return filteredBranches, nil
+	return branchNames, nil
 }
 
-func (r *PreviewEnvironmentReconciler) buildPreviewEnvironmentInstanceForPr(pe coflnetv1alpha1.PreviewEnvironment, pullRequest *github.PullRequest) *coflnetv1alpha1.PreviewEnvironmentInstance {
+func (r *PreviewEnvironmentReconciler) buildPreviewEnvironmentInstanceForPr(pe coflnetv1alpha1.PreviewEnvironment, pullRequest git.PullRequest) *coflnetv1alpha1.PreviewEnvironmentInstance {
 
 	name := coflnetv1alpha1.PreviewEnvironmentInstanceNameFromPullRequest(
 		pe.GetName(),
 		pe.GetOwner(),
 		pe.Spec.GitSettings.Organization,
 		pe.Spec.GitSettings.Repository,
-		int(pullRequest.GetNumber()),
+		pullRequest.Number,
 	)
 
 	gitSettings := coflnetv1alpha1.InstanceGitSettings{
-		PullRequestNumber: intPtr(int(pullRequest.GetNumber())),
-		Branch:            strPtr(pullRequest.GetHead().GetRef()),
+		PullRequestNumber: intPtr(pullRequest.Number),
+		Branch:            strPtr(pullRequest.HeadBranch),
 		CommitHash:        "",
+		Fork:              pullRequest.Fork,
 	}
 
 	return createInstanceFromEnvironment(pe, name, gitSettings)
@@ -210,7 +358,7 @@ func createInstanceFromEnvironment(pe coflnetv1alpha1.PreviewEnvironment, name s
 				"previewenvironment":  string(pe.GetUID()),
 				"github-organization": pe.Spec.GitSettings.Organization,
 				"github-repository":   pe.Spec.GitSettings.Repository,
-				"github-identifier":   gitSettings.BranchOrPullRequestIdentifier(),
+				"github-identifier":   coflnetv1alpha1.SafeIdentifier(gitSettings.BranchOrPullRequestIdentifier()),
 			},
 		},
 		Spec: coflnetv1alpha1.PreviewEnvironmentInstanceSpec{
@@ -225,14 +373,14 @@ func createInstanceFromEnvironment(pe coflnetv1alpha1.PreviewEnvironment, name s
 
 func (r *PreviewEnvironmentReconciler) savePreviewEnvironmentInstances(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, peis []*coflnetv1alpha1.PreviewEnvironmentInstance) error {
 	for _, pei := range peis {
-		if err := r.savePreviewEnvironmentInstance(ctx, pei); err != nil {
+		if err := r.savePreviewEnvironmentInstance(ctx, pe, pei); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (r *PreviewEnvironmentReconciler) savePreviewEnvironmentInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+func (r *PreviewEnvironmentReconciler) savePreviewEnvironmentInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
 	r.log.Info("saving preview environment instance", "namespace", pei.Namespace, "name", pei.Name)
 
 	existingPei := &coflnetv1alpha1.PreviewEnvironmentInstance{
@@ -251,31 +399,55 @@ func (r *PreviewEnvironmentReconciler) savePreviewEnvironmentInstance(ctx contex
 
 	// update the preview environment instance
 	if existingPei.Spec.DesiredPhase != "" {
-		pei.ObjectMeta = existingPei.ObjectMeta
-		err = r.Update(ctx, pei)
-		if err != nil {
+		updated := existingPei.DeepCopy()
+		updated.Labels = pei.Labels
+
+		// the commit hash and the desired phase are managed by the instance itself
+		updated.Spec.InstanceGitSettings.PullRequestNumber = pei.Spec.InstanceGitSettings.PullRequestNumber
+		updated.Spec.InstanceGitSettings.Branch = pei.Spec.InstanceGitSettings.Branch
+		updated.Spec.InstanceGitSettings.Fork = pei.Spec.InstanceGitSettings.Fork
+
+		// the pull request or branch is active again, undo a previous stale cleanup
+		if _, stale := updated.Annotations[staleSinceAnnotation]; stale {
+			r.log.Info("preview environment instance is not stale anymore", "namespace", pei.Namespace, "name", pei.Name)
+			delete(updated.Annotations, staleSinceAnnotation)
+			updated.Spec.DesiredPhase = coflnetv1alpha1.InstancePhaseRunning
+		}
+
+		// instances created before owner references were introduced get one on the next update
+		if err := controllerutil.SetControllerReference(pe, updated, r.Scheme); err != nil {
 			return err
 		}
 
-		return nil
+		if equality.Semantic.DeepEqual(existingPei, updated) {
+			return nil
+		}
+
+		return r.Update(ctx, updated)
 	}
 
 	// create the preview environment instance
+	// the instance is owned by the preview environment
+	if err := controllerutil.SetControllerReference(pe, pei, r.Scheme); err != nil {
+		return err
+	}
 	return r.Create(ctx, pei)
 }
 
 func (r *PreviewEnvironmentReconciler) deletePreviewEnvironmentInstancesForPreviewEnvironment(ctx context.Context, pr coflnetv1alpha1.PreviewEnvironment) error {
 	r.log.Info("deleting preview environment instances for preview environment", "namespace", pr.Namespace, "name", pr.Name)
 
+	// only delete the instances of this preview environment
+	// other preview environments in the same namespace must not be affected
 	var peis coflnetv1alpha1.PreviewEnvironmentInstanceList
-	if err := r.List(ctx, &peis, client.InNamespace(pr.Namespace), &client.ListOptions{}); err != nil {
+	if err := r.List(ctx, &peis, client.InNamespace(pr.Namespace), client.MatchingLabels{"previewenvironment": string(pr.GetUID())}); err != nil {
 		return err
 	}
 
 	r.log.Info("loaded preview environment instances", "count", len(peis.Items), "namespace", pr.Namespace, "name", pr.Name)
 	for _, pei := range peis.Items {
 		r.log.Info("deleting preview environment instance", "pei", pei.Name, "namespace", pei.Namespace)
-		if err := r.Delete(ctx, &pei); err != nil {
+		if err := r.Delete(ctx, &pei); client.IgnoreNotFound(err) != nil {
 			return err
 		}
 	}
@@ -284,10 +456,10 @@ func (r *PreviewEnvironmentReconciler) deletePreviewEnvironmentInstancesForPrevi
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *PreviewEnvironmentReconciler) SetupWithManager(mgr ctrl.Manager, gc *git.GithubClient) error {
+func (r *PreviewEnvironmentReconciler) SetupWithManager(mgr ctrl.Manager, providers *git.Providers, bus *events.Bus) error {
 	r.log = log.FromContext(context.TODO())
 
-	r.githubClient = gc
+	r.gitProviders = providers
 
 	// setup the indexer stuff
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &coflnetv1alpha1.PreviewEnvironmentInstance{}, "spec.previewEnvironmentRef.name", func(o client.Object) []string {
@@ -305,6 +477,13 @@ func (r *PreviewEnvironmentReconciler) SetupWithManager(mgr ctrl.Manager, gc *gi
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&coflnetv1alpha1.PreviewEnvironment{}).
+		// instances change all the time while they are built and deployed
+		// the preview environment only has to react if an instance gets created or deleted
+		Owns(&coflnetv1alpha1.PreviewEnvironmentInstance{}, builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(event.UpdateEvent) bool { return false },
+		})).
+		// webhooks report new and closed pull requests through the event bus
+		WatchesRawSource(bus.PreviewEnvironmentSource()).
 		Named("previewenvironment").
 		Complete(r)
 }
@@ -23,62 +23,148 @@ import (
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
 )
 
 var _ = Describe("PreviewEnvironment Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
+		const commit = "0123456789abcdef0123456789abcdef01234567"
 
 		ctx := context.Background()
 
 		typeNamespacedName := types.NamespacedName{
 			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+			Namespace: "default",
 		}
-		previewenvironment := &coflnetv1alpha1.PreviewEnvironment{}
+
+		var provider *fakeProvider
+		var controllerReconciler *PreviewEnvironmentReconciler
+
+		reconcileEnvironment := func() {
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		loadInstance := func(name string) *coflnetv1alpha1.PreviewEnvironmentInstance {
+			pei := &coflnetv1alpha1.PreviewEnvironmentInstance{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, pei)).To(Succeed())
+			return pei
+		}
+
+		pullRequestInstanceName := coflnetv1alpha1.PreviewEnvironmentInstanceNameFromPullRequest(resourceName, "test-owner", "coflnet", "preview", 3)
+		branchInstanceName := coflnetv1alpha1.PreviewEnvironmentInstanceNameFromBranch(resourceName, "test-owner", "coflnet", "preview", "main")
 
 		BeforeEach(func() {
+			provider = &fakeProvider{
+				pullRequests: []git.PullRequest{{Number: 3, HeadBranch: "feature", HeadSha: commit, BaseBranch: "main"}},
+				branches:     []git.Branch{{Name: "main", HeadSha: commit}},
+			}
+			controllerReconciler = &PreviewEnvironmentReconciler{
+				Client:       k8sClient,
+				Scheme:       k8sClient.Scheme(),
+				gitProviders: fakeProviders(provider),
+			}
+
 			By("creating the custom resource for the Kind PreviewEnvironment")
-			err := k8sClient.Get(ctx, typeNamespacedName, previewenvironment)
-			if err != nil && errors.IsNotFound(err) {
-				resource := &coflnetv1alpha1.PreviewEnvironment{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
+			resource := &coflnetv1alpha1.PreviewEnvironment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+					Labels:    map[string]string{"owner": "test-owner"},
+				},
+				Spec: coflnetv1alpha1.PreviewEnvironmentSpec{
+					GitSettings: coflnetv1alpha1.GitSettings{
+						Organization: "coflnet",
+						Repository:   "preview",
+					},
+					ContainerRegistry: &coflnetv1alpha1.ContainerRegistry{
+						Registry:   "registry.example.com",
+						Repository: "previews",
+					},
+					ApplicationSettings: coflnetv1alpha1.ApplicationSettings{
+						IngressHostname: "preview.example.com",
+						Port:            8080,
+					},
+					BuildSettings: coflnetv1alpha1.BuildSettings{
+						BuildAllBranches:     true,
+						BuildAllPullRequests: true,
 					},
-					// TODO(user): Specify other spec details if needed.
-				}
-				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+					DisplayName: "Preview",
+					AccessSettings: coflnetv1alpha1.AccessSettings{
+						Users:        []coflnetv1alpha1.UserAccess{},
+						PublicAccess: true,
+					},
+				},
 			}
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 		})
 
 		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
 			resource := &coflnetv1alpha1.PreviewEnvironment{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
+			uid := resource.GetUID()
+
 			By("Cleanup the specific resource instance PreviewEnvironment")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+
+			By("removing the instances and the finalizer")
+			reconcileEnvironment()
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, typeNamespacedName, resource))).To(BeTrue())
+
+			instances := &coflnetv1alpha1.PreviewEnvironmentInstanceList{}
+			Expect(k8sClient.List(ctx, instances, client.InNamespace("default"), client.MatchingLabels{"previewenvironment": string(uid)})).To(Succeed())
+			Expect(instances.Items).To(BeEmpty())
 		})
-		It("should successfully reconcile the resource", func() {
+
+		It("should create an instance for every pull request and branch of the repository", func() {
 			By("Reconciling the created resource")
-			controllerReconciler := &PreviewEnvironmentReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
-			}
+			reconcileEnvironment()
 
-			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
-				NamespacedName: typeNamespacedName,
-			})
+			pe := &coflnetv1alpha1.PreviewEnvironment{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, pe)).To(Succeed())
+			Expect(pe.Status.Phase).To(Equal(coflnetv1alpha1.PreviewEnvironmentPhaseReady))
+			Expect(pe.Status.PullRequestsDetected).To(ConsistOf(3))
+
+			pullRequestInstance := loadInstance(pullRequestInstanceName)
+			Expect(metav1.IsControlledBy(pullRequestInstance, pe)).To(BeTrue())
+			Expect(pullRequestInstance.GetLabels()).To(HaveKeyWithValue("previewenvironment", string(pe.GetUID())))
+			Expect(pullRequestInstance.Spec.InstanceGitSettings.PullRequestNumber).To(HaveValue(Equal(3)))
+			Expect(pullRequestInstance.Spec.InstanceGitSettings.Branch).To(HaveValue(Equal("feature")))
+			Expect(pullRequestInstance.Spec.DesiredPhase).To(Equal(coflnetv1alpha1.InstancePhaseRunning))
+
+			branchInstance := loadInstance(branchInstanceName)
+			Expect(metav1.IsControlledBy(branchInstance, pe)).To(BeTrue())
+			Expect(branchInstance.Spec.InstanceGitSettings.PullRequestNumber).To(BeNil())
+			Expect(branchInstance.Spec.InstanceGitSettings.Branch).To(HaveValue(Equal("main")))
+		})
+
+		It("should delete the instance of a closed pull request", func() {
+			reconcileEnvironment()
+			loadInstance(pullRequestInstanceName)
+
+			By("closing the pull request")
+			provider.pullRequests = nil
+			reconcileEnvironment()
+
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: pullRequestInstanceName, Namespace: "default"}, &coflnetv1alpha1.PreviewEnvironmentInstance{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+			Expect(loadInstance(branchInstanceName).GetAnnotations()).NotTo(HaveKey(staleSinceAnnotation))
+
+			comments, err := provider.Comments(ctx, "coflnet", "preview", 3)
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			Expect(comments).To(HaveLen(1))
+			Expect(comments[0].Body).To(ContainSubstring("has been removed"))
 		})
 	})
 })
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"slices"
 	"strings"
-	"time"
 
 	kbatch "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -13,90 +12,190 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
 )
 
 const (
 	buildPrefix = "build-"
+
+	// buildTimeout is the maximum time a build job is allowed to run
+	buildTimeout = 30 * 60
+
+	// buildJobTTL is how long a finished build job is kept, well above the maximum backoff of the reconciler
+	// the result of the job is only recorded once the controller looked at it, and the logs command reads the logs of the job
+	buildJobTTL = 6 * 60 * 60
+
+	buildJobInstanceLabel = "previewenvironmentinstance"
+	buildJobCommitLabel   = "commit-hash"
+)
+
+// buildResult describes the state of the build for the current commit of an instance
+type buildResult int
+
+const (
+	buildResultRunning buildResult = iota
+	buildResultSucceeded
+	buildResultFailed
+	buildResultMissing
 )
 
-func (r *PreviewEnvironmentInstanceReconciler) rebuildInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+// startBuild creates the build job for the current commit of the instance
+// it does not wait for the job to finish, the controller gets notified about job changes through the job watch
+// returns false if no build is necessary
+func (r *PreviewEnvironmentInstanceReconciler) startBuild(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, error) {
 	if pei.Spec.InstanceGitSettings.CommitHash == "" {
 		r.log.Info("No commit hash available, skip this build", "namespace", pei.Namespace, "name", pei.Name)
-		return nil
+		return false, nil
 	}
 
 	// check if a build version is already available
-	builtAvailable := false
-	if pei.Status.BuiltVersions != nil {
-		for _, version := range pei.Status.BuiltVersions {
-			if version.Tag == pei.Spec.InstanceGitSettings.CommitHash {
-				builtAvailable = true
-				break
-			}
-		}
+	if builtVersionAvailable(pei, pei.Spec.InstanceGitSettings.CommitHash) {
+		r.log.Info("Built version is already available, skip this build", "namespace", pei.Namespace, "name", pei.Name)
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionBuildSucceeded, metav1.ConditionTrue, "ImageAvailable", fmt.Sprintf("image for commit %s was already built", pei.Spec.InstanceGitSettings.CommitHash))
+		return false, nil
 	}
 
-	if builtAvailable {
-		r.log.Info("Built version is already available, skip this build", "namespace", pei.Namespace, "name", pei.Name)
-		return nil
+	// jobs of older commits are not needed anymore
+	err := r.deleteOutdatedBuildJobs(ctx, pei)
+	if err != nil {
+		return false, err
 	}
 
-	if pei.Spec.InstanceGitSettings.CommitHash == "" {
-		r.log.Info("No commit hash available, skip this build", "namespace", pei.Namespace, "name", pei.Name)
-		return nil
+	provider, err := r.gitProviders.ForEnvironment(ctx, pe)
+	if err != nil {
+		return false, err
 	}
 
-	// build the container image
-	r.log.Info("Building container image for PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
-	err := r.buildContainerImage(ctx, pe, pei)
+	// forks are cloned anonymously, the code of the fork must not get hold of the token of the repository
+	hasCredentials := false
+	if !pei.Spec.InstanceGitSettings.Fork {
+		hasCredentials, err = r.deployGitCredentialsSecret(ctx, pe, pei, provider)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	job, err := r.buildJobForInstance(pe, pei, provider, hasCredentials)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+
+	r.log.Info("Creating kaniko job", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name)
+	if err := r.Create(ctx, job); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return false, err
+		}
+		r.log.Info("Job already exists, waiting for it to finish", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name)
+	}
+
+	now := metav1.Now()
+	pei.Status.Phase = coflnetv1alpha1.InstancePhaseBuilding
+	pei.Status.Build = &coflnetv1alpha1.BuildStatus{
+		JobName:    job.Name,
+		CommitHash: pei.Spec.InstanceGitSettings.CommitHash,
+		StartTime:  &now,
+	}
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionBuildSucceeded, metav1.ConditionFalse, "Building", fmt.Sprintf("building commit %s in job %s", pei.Spec.InstanceGitSettings.CommitHash, job.Name))
+	pei.Status.ObservedGeneration = pei.GetGeneration()
+	return true, r.Status().Update(ctx, pei)
 }
 
-func (r *PreviewEnvironmentInstanceReconciler) buildContainerImage(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+// checkBuild looks at the build job of the instance and records the result in the status
+func (r *PreviewEnvironmentInstanceReconciler) checkBuild(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (buildResult, error) {
+	if pei.Status.Build == nil || pei.Status.Build.JobName == "" {
+		return buildResultMissing, nil
+	}
+
 	job := &kbatch.Job{}
+	if err := r.Get(ctx, types.NamespacedName{Name: pei.Status.Build.JobName, Namespace: pei.Namespace}, job); err != nil {
+		if errors.IsNotFound(err) {
+			return buildResultMissing, nil
+		}
+		return buildResultRunning, err
+	}
 
-	r.log.Info("Checking if job already exists", "namespace", pei.Namespace, "name", pei.Name)
-	if err := r.Get(ctx, types.NamespacedName{Name: pei.Name, Namespace: pei.Namespace}, job); err != nil {
-		if !errors.IsNotFound(err) {
-			r.log.Error(err, "Failed to get job", "namespace", pei.Namespace, "name", pei.Name)
-			return err
+	// the job of a previous build is still being deleted, e.g. after a rebuild was requested
+	// the job watch triggers the next check once it is gone
+	if !job.DeletionTimestamp.IsZero() {
+		return buildResultRunning, nil
+	}
+
+	if job.Status.Succeeded > 0 {
+		r.log.Info("Kaniko job succeeded", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name)
+		finishBuild(pei, job, "")
+		pei.Status.BuiltVersions = updateBuiltVersions(pei.Status.BuiltVersions, pei.Status.Build.CommitHash, 10)
+		message := fmt.Sprintf("built commit %s", pei.Status.Build.CommitHash)
+		if pei.Status.Build.Duration != nil {
+			message = fmt.Sprintf("%s in %s", message, pei.Status.Build.Duration.Duration)
 		}
-		r.log.Info("Job not found, creating", "namespace", pei.Namespace, "name", pei.Name)
-	} else {
-		if job.Status.Active > 0 {
-			r.log.Info("Job already running, skipping", "namespace", pei.Namespace, "name", pei.Name)
-			return nil
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionBuildSucceeded, metav1.ConditionTrue, "BuildSucceeded", message)
+		return buildResultSucceeded, nil
+	}
+
+	if reason, failed := jobFailureReason(job); failed {
+		r.log.Info("Kaniko job failed", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name, "reason", reason)
+		finishBuild(pei, job, reason)
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionBuildSucceeded, metav1.ConditionFalse, "BuildFailed", reason)
+		return buildResultFailed, nil
+	}
+
+	return buildResultRunning, nil
+}
+
+// deleteOutdatedBuildJobs deletes all build jobs of the instance that do not build the current commit
+func (r *PreviewEnvironmentInstanceReconciler) deleteOutdatedBuildJobs(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	var jobs kbatch.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(pei.Namespace), client.MatchingLabels{buildJobInstanceLabel: pei.Name}); err != nil {
+		return err
+	}
+
+	for _, job := range jobs.Items {
+		if job.GetLabels()[buildJobCommitLabel] == pei.Spec.InstanceGitSettings.CommitHash || !job.DeletionTimestamp.IsZero() {
+			continue
 		}
 
-		if job.Status.Succeeded > 0 {
-			r.log.Info("Job already succeeded, deleting", "namespace", pei.Namespace, "name", pei.Name)
-			err = r.Delete(ctx, job)
-			if err != nil {
-				return err
-			}
+		r.log.Info("Deleting outdated kaniko job", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name)
+		if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
 		}
 	}
 
-	pei.Status.Phase = coflnetv1alpha1.InstancePhaseBuilding
-	err := r.Status().Update(ctx, pei)
-	if err != nil {
-		return err
+	return nil
+}
+
+func finishBuild(pei *coflnetv1alpha1.PreviewEnvironmentInstance, job *kbatch.Job, failureReason string) {
+	completionTime := metav1.Now()
+	if job.Status.CompletionTime != nil {
+		completionTime = *job.Status.CompletionTime
 	}
 
-	err = r.buildAndWaitForContainerImage(ctx, pe, pei)
-	if err != nil {
-		r.log.Error(err, "Failed to build container image", "namespace", pei.Namespace, "name", pei.Name)
-		return err
+	pei.Status.Build.CompletionTime = &completionTime
+	pei.Status.Build.FailureReason = failureReason
+	if pei.Status.Build.StartTime != nil {
+		pei.Status.Build.Duration = &metav1.Duration{Duration: completionTime.Sub(pei.Status.Build.StartTime.Time)}
+	}
+}
+
+// jobFailureReason returns the reason of the failed condition of the job
+func jobFailureReason(job *kbatch.Job) (string, bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Type == kbatch.JobFailed && c.Status == corev1.ConditionTrue {
+			return fmt.Sprintf("%s: %s", c.Reason, c.Message), true
+		}
 	}
+	return "", false
+}
 
-	pei.Status.BuiltVersions = updateBuiltVersions(pei.Status.BuiltVersions, pei.Spec.InstanceGitSettings.CommitHash, 10)
-	r.log.Info("Updating PreviewEnvironmentInstance status", "namespace", pei.Namespace, "name", pei.Name)
-	return err
+func builtVersionAvailable(pei *coflnetv1alpha1.PreviewEnvironmentInstance, commitHash string) bool {
+	for _, version := range pei.Status.BuiltVersions {
+		if version.Tag == commitHash {
+			return true
+		}
+	}
+	return false
 }
 
 func updateBuiltVersions(versions []coflnetv1alpha1.BuiltVersion, commitHash string, keep int) []coflnetv1alpha1.BuiltVersion {
@@ -120,149 +219,263 @@ func updateBuiltVersions(versions []coflnetv1alpha1.BuiltVersion, commitHash str
 	return versions
 }
 
-func (r *PreviewEnvironmentInstanceReconciler) buildAndWaitForContainerImage(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	const kanikoSecret = "dockerhub"
-	var jobName = fmt.Sprintf("%s%s", buildPrefix, pei.Name)
-	var destination = coflnetv1alpha1.PreviewEnvironmentInstanceContainerName(pe, pei.BranchOrPullRequestIdentifier(), pei.Spec.InstanceGitSettings.CommitHash)
+// buildJobName returns the name of the build job for the current commit of the instance
+func buildJobName(pei *coflnetv1alpha1.PreviewEnvironmentInstance) string {
+	commitHash := pei.Spec.InstanceGitSettings.CommitHash
+	if len(commitHash) > 7 {
+		commitHash = commitHash[:7]
+	}
+	return fmt.Sprintf("%s%s-%s", buildPrefix, pei.Name, commitHash)
+}
 
-	kanikoJob := &kbatch.Job{
+// deployGitCredentialsSecret stores the credentials kaniko uses to clone the repository
+// the credentials are refreshed before every build, github installation tokens are only valid for an hour
+// returns false if the provider has no credentials, the repository is cloned anonymously then
+func (r *PreviewEnvironmentInstanceReconciler) deployGitCredentialsSecret(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, provider git.Provider) (bool, error) {
+	credentials, err := provider.CloneCredentials(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository)
+	if err != nil {
+		return false, err
+	}
+	if credentials == nil {
+		return false, client.IgnoreNotFound(r.Delete(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pei.NameForGitCredentials(),
+				Namespace: pei.GetNamespace(),
+			},
+		}))
+	}
+
+	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      jobName,
-			Namespace: pei.Namespace,
-		},
-		Spec: kbatch.JobSpec{
-			TTLSecondsAfterFinished: int32Ptr(60),
-			Template: corev1.PodTemplateSpec{
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyOnFailure,
-					Containers: []corev1.Container{
-						{
-							Name:  "kaniko",
-							Image: "gcr.io/kaniko-project/executor:v1.23.2",
-							Args: []string{
-								"--dockerfile=Dockerfile",
-								fmt.Sprintf("--context=git://github.com/%s/%s.git#refs/heads/%s", pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.Branch),
-								fmt.Sprintf("--destination=%s", destination),
-								fmt.Sprintf("--custom-platform=%s", "linux/amd64"),
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      kanikoSecret,
-									MountPath: "/kaniko/.docker",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: kanikoSecret,
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: kanikoSecret,
-									Items: []corev1.KeyToPath{
-										{
-											Key:  ".dockerconfigjson",
-											Path: "config.json",
-										},
-									},
-								},
-							},
-						},
-					},
-				},
+			Name:      pei.NameForGitCredentials(),
+			Namespace: pei.GetNamespace(),
+			Labels: map[string]string{
+				"owner": pe.GetOwner(),
 			},
 		},
+		Type: corev1.SecretTypeBasicAuth,
+		StringData: map[string]string{
+			corev1.BasicAuthUsernameKey: credentials.Username,
+			corev1.BasicAuthPasswordKey: credentials.Password,
+		},
 	}
 
-	var kJob kbatch.Job
-	if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: pei.Namespace}, &kJob); err == nil {
-		r.log.Info("Deleting existing kaniko job", "namespace", pei.Namespace, "name", pei.Name)
-		if err := r.Delete(ctx, &kJob); err != nil {
-			return err
-		}
+	if err := controllerutil.SetControllerReference(pei, secret, r.Scheme); err != nil {
+		return false, err
+	}
+
+	var kSecret corev1.Secret
+	err = r.Get(ctx, client.ObjectKey{Namespace: secret.GetNamespace(), Name: secret.GetName()}, &kSecret)
+	if err == nil {
+		return true, r.Update(ctx, secret)
+	}
+	if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	r.log.Info("Creating git credentials", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	return true, r.Create(ctx, secret)
+}
 
-		for {
-			if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: pei.Namespace}, &kJob); err != nil {
-				if errors.IsNotFound(err) {
-					break
-				}
-				return err
-			}
-			time.Sleep(time.Second * 1)
+// gitCredentialsEnv passes the clone credentials to kaniko, it uses them for the git context
+func gitCredentialsEnv(pei *coflnetv1alpha1.PreviewEnvironmentInstance) []corev1.EnvVar {
+	secretEnv := func(name, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: pei.NameForGitCredentials()},
+					Key:                  key,
+				},
+			},
 		}
 	}
 
-	r.log.Info("Creating kaniko job", "namespace", pei.Namespace, "name", pei.Name, "destination", destination)
-	if err := r.Create(ctx, kanikoJob); err != nil {
-		return err
+	return []corev1.EnvVar{
+		secretEnv("GIT_USERNAME", corev1.BasicAuthUsernameKey),
+		secretEnv("GIT_PASSWORD", corev1.BasicAuthPasswordKey),
 	}
+}
 
-	counter := 0
-	time.Sleep(time.Second * 10)
-	for {
-		job := &kbatch.Job{}
-		if err := r.Get(ctx, types.NamespacedName{Name: jobName, Namespace: pei.Namespace}, job); err != nil {
-			return err
-		}
+const (
+	// kanikoSecret holds the credentials of the container registry
+	kanikoSecret = "dockerhub"
 
-		if job.Status.Succeeded > 0 {
-			break
-		}
+	kanikoImage = "gcr.io/kaniko-project/executor:v1.23.2"
 
-		if job.Status.Failed > 0 {
-			err := fmt.Errorf("kaniko job failed")
-			r.log.Error(err, "kaniko job failed", "namespace", pei.Namespace, "name", pei.Name)
-			return err
-		}
+	// craneImage pushes the image of fork builds, kaniko builds them without any credentials
+	craneImage = "gcr.io/go-containerregistry/crane:v0.20.2"
+)
 
-		time.Sleep(time.Second * 1)
-		if counter > 60*30 {
-			err := fmt.Errorf("timeout while waiting for kaniko job to finish")
-			r.log.Error(err, "timeout while waiting for kaniko job to finish", "namespace", pei.Namespace, "name", pei.Name)
-			return err
-		}
+func (r *PreviewEnvironmentInstanceReconciler) buildJobForInstance(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, provider git.Provider, hasCredentials bool) (*kbatch.Job, error) {
+	var env []corev1.EnvVar
+	if hasCredentials {
+		env = gitCredentialsEnv(pei)
 	}
 
-	go func() {
-		err := r.deleteCompletedPods(ctx, pei)
-		if err != nil {
-			r.log.Error(err, "Failed to delete completed pods", "namespace", pei.Namespace, "name", pei.Name)
+	var destination = coflnetv1alpha1.PreviewEnvironmentInstanceContainerName(pe, pei.SafeIdentifier(), pei.Spec.InstanceGitSettings.CommitHash)
+
+	gitSettings := pei.Spec.InstanceGitSettings
+	var podSpec corev1.PodSpec
+	if gitSettings.Fork {
+		// the branch of a fork does not exist in the repository, the commit is cloned through the pull request
+		if gitSettings.PullRequestNumber == nil {
+			return nil, fmt.Errorf("instance of a fork has no pull request")
+		}
+		gitContext := provider.PullRequestCloneUrl(pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *gitSettings.PullRequestNumber, gitSettings.CommitHash)
+		podSpec = forkBuildPodSpec(kanikoArgs(&pe.Spec.BuildSettings, gitContext, destination), destination)
+	} else {
+		if gitSettings.Branch == nil {
+			return nil, fmt.Errorf("instance has no branch to build")
 		}
-	}()
+		gitContext := provider.CloneUrl(pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *gitSettings.Branch, gitSettings.CommitHash)
+		podSpec = kanikoPodSpec(kanikoArgs(&pe.Spec.BuildSettings, gitContext, destination), env)
+	}
 
-	return nil
+	kanikoJob := &kbatch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      buildJobName(pei),
+			Namespace: pei.Namespace,
+			Labels: map[string]string{
+				"owner":               pe.GetOwner(),
+				buildJobInstanceLabel: pei.Name,
+				buildJobCommitLabel:   pei.Spec.InstanceGitSettings.CommitHash,
+			},
+		},
+		Spec: kbatch.JobSpec{
+			TTLSecondsAfterFinished: int32Ptr(buildJobTTL),
+			ActiveDeadlineSeconds:   int64Ptr(buildTimeout),
+			Template: corev1.PodTemplateSpec{
+				Spec: podSpec,
+			},
+		},
+	}
+
+	// the job is owned by the instance, that way the controller gets notified when the job changes
+	if err := controllerutil.SetControllerReference(pei, kanikoJob, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return kanikoJob, nil
 }
 
-func (r *PreviewEnvironmentInstanceReconciler) deleteCompletedPods(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	pods := &corev1.PodList{}
-	// PERF: it would be cool to use field selectors here
-	// that way we only get the pods we need
-	if err := r.List(ctx, pods, &client.ListOptions{
-		Namespace: pei.Namespace,
-	}); err != nil {
-		return err
+// kanikoArgs returns the arguments of kaniko for the build settings of the preview environment
+func kanikoArgs(buildSettings *coflnetv1alpha1.BuildSettings, gitContext, destination string) []string {
+	args := []string{
+		fmt.Sprintf("--dockerfile=%s", buildSettings.Dockerfile()),
+		fmt.Sprintf("--context=%s", gitContext),
+		fmt.Sprintf("--destination=%s", destination),
+		fmt.Sprintf("--custom-platform=%s", "linux/amd64"),
 	}
 
-	// HACK: there has to be a better way to do this
-	for _, pod := range pods.Items {
-		if !strings.HasPrefix(pod.Name, buildPrefix) {
-			continue
-		}
+	// kaniko clones the whole repository and builds in the subdirectory, e.g. a service of a monorepo
+	if buildSettings.ContextPath != nil && strings.Trim(*buildSettings.ContextPath, "/") != "" {
+		args = append(args, fmt.Sprintf("--context-sub-path=%s", strings.Trim(*buildSettings.ContextPath, "/")))
+	}
+	if buildSettings.Target != nil && *buildSettings.Target != "" {
+		args = append(args, fmt.Sprintf("--target=%s", *buildSettings.Target))
+	}
+	for _, arg := range buildSettings.BuildArgs {
+		args = append(args, fmt.Sprintf("--build-arg=%s=%s", arg.Key, arg.Value))
+	}
+	return args
+}
 
-		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
-			statuses := pod.Status.ContainerStatuses
-			for _, status := range statuses {
-				if status.State.Terminated != nil {
-					age := time.Now().Sub(status.State.Terminated.FinishedAt.Time)
-					if age > time.Minute*3 {
-						if err := r.Delete(ctx, &pod); err != nil {
-							return err
-						}
-					}
-				}
-			}
-		}
+// kanikoPodSpec builds the image and pushes it with the registry credentials that are mounted into kaniko
+func kanikoPodSpec(args []string, env []corev1.EnvVar) corev1.PodSpec {
+	return corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyOnFailure,
+		Containers: []corev1.Container{
+			{
+				Name:  "kaniko",
+				Image: kanikoImage,
+				Args:  args,
+				Env:   env,
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      kanikoSecret,
+						MountPath: "/kaniko/.docker",
+					},
+				},
+			},
+		},
+		Volumes: []corev1.Volume{registryCredentialsVolume()},
 	}
+}
 
-	return nil
+// forkBuildPodSpec builds the image of a fork without any credentials, the dockerfile of the fork runs inside of kaniko
+// kaniko only writes the image to a tarball, crane pushes it afterwards, it is the only container that sees the registry credentials
+// that way the fork can not push anything else than its preview tag
+func forkBuildPodSpec(args []string, destination string) corev1.PodSpec {
+	const workspace = "workspace"
+	const imageTar = "/workspace/image.tar"
+
+	return corev1.PodSpec{
+		RestartPolicy:                corev1.RestartPolicyOnFailure,
+		AutomountServiceAccountToken: boolPtr(false),
+		InitContainers: []corev1.Container{
+			{
+				Name:  "kaniko",
+				Image: kanikoImage,
+				Args:  append(args, "--no-push", fmt.Sprintf("--tar-path=%s", imageTar)),
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      workspace,
+						MountPath: "/workspace",
+					},
+				},
+			},
+		},
+		Containers: []corev1.Container{
+			{
+				Name:  "push",
+				Image: craneImage,
+				Args:  []string{"push", imageTar, destination},
+				Env: []corev1.EnvVar{
+					{
+						Name:  "DOCKER_CONFIG",
+						Value: "/docker",
+					},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      workspace,
+						MountPath: "/workspace",
+						ReadOnly:  true,
+					},
+					{
+						Name:      kanikoSecret,
+						MountPath: "/docker",
+						ReadOnly:  true,
+					},
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			registryCredentialsVolume(),
+			{
+				Name: workspace,
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		},
+	}
+}
+
+func registryCredentialsVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: kanikoSecret,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: kanikoSecret,
+				Items: []corev1.KeyToPath{
+					{
+						Key:  ".dockerconfigjson",
+						Path: "config.json",
+					},
+				},
+			},
+		},
+	}
 }
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+func TestKanikoArgs(t *testing.T) {
+	const (
+		gitContext  = "git://github.com/coflnet/pr-env.git#refs/heads/main#abc1234"
+		destination = "registry.example.com/coflnet/pr-env:main-abc1234"
+	)
+	defaultArgs := []string{
+		"--dockerfile=Dockerfile",
+		"--context=" + gitContext,
+		"--destination=" + destination,
+		"--custom-platform=linux/amd64",
+	}
+
+	tests := []struct {
+		name     string
+		settings coflnetv1alpha1.BuildSettings
+		expected []string
+	}{
+		{
+			name:     "defaults",
+			expected: defaultArgs,
+		},
+		{
+			name:     "empty build args",
+			settings: coflnetv1alpha1.BuildSettings{BuildArgs: []coflnetv1alpha1.BuildArg{}},
+			expected: defaultArgs,
+		},
+		{
+			name:     "empty optional settings",
+			settings: coflnetv1alpha1.BuildSettings{DockerfilePath: strPtr(""), ContextPath: strPtr("/"), Target: strPtr("")},
+			expected: defaultArgs,
+		},
+		{
+			name:     "dockerfile",
+			settings: coflnetv1alpha1.BuildSettings{DockerfilePath: strPtr("docker/api.Dockerfile")},
+			expected: append([]string{"--dockerfile=docker/api.Dockerfile"}, defaultArgs[1:]...),
+		},
+		{
+			name:     "context sub path without slashes",
+			settings: coflnetv1alpha1.BuildSettings{ContextPath: strPtr("/services/api/")},
+			expected: append(slices.Clone(defaultArgs), "--context-sub-path=services/api"),
+		},
+		{
+			name:     "target",
+			settings: coflnetv1alpha1.BuildSettings{Target: strPtr("runtime")},
+			expected: append(slices.Clone(defaultArgs), "--target=runtime"),
+		},
+		{
+			name: "build args keep their order",
+			settings: coflnetv1alpha1.BuildSettings{BuildArgs: []coflnetv1alpha1.BuildArg{
+				{Key: "VERSION", Value: "1.2.3"},
+				{Key: "EMPTY", Value: ""},
+				{Key: "FLAGS", Value: "a=b c"},
+			}},
+			expected: append(slices.Clone(defaultArgs), "--build-arg=VERSION=1.2.3", "--build-arg=EMPTY=", "--build-arg=FLAGS=a=b c"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := kanikoArgs(&tt.settings, gitContext, destination)
+			if !slices.Equal(actual, tt.expected) {
+				t.Errorf("kanikoArgs() = %q, expected %q", actual, tt.expected)
+			}
+		})
+	}
+}
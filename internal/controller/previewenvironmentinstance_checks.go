@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	kbatch "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
+	"github.com/coflnet/pr-env/internal/kubeclient"
+)
+
+// buildLogTailLines how many lines of the build logs are attached to the check
+const buildLogTailLines = 50
+
+// instancePhaseForCheck the phase an instance is in or moves to when the check state is reported
+var instancePhaseForCheck = map[string]string{
+	git.InstanceCheckQueued:           coflnetv1alpha1.InstancePhasePending,
+	git.InstanceCheckAwaitingApproval: coflnetv1alpha1.InstancePhaseAwaitingApproval,
+	git.InstanceCheckBuilding:         coflnetv1alpha1.InstancePhaseBuilding,
+	git.InstanceCheckDeploying:        coflnetv1alpha1.InstancePhaseDeploying,
+	git.InstanceCheckSuccess:          coflnetv1alpha1.InstancePhaseRunning,
+	git.InstanceCheckFailure:          coflnetv1alpha1.InstancePhaseFailed,
+}
+
+// reportCheck reports the state of the instance commit to the git provider, as a check, as a deployment and in the pull request comment
+// failing to report should not block the instance, the error is only logged
+func (r *PreviewEnvironmentInstanceReconciler) reportCheck(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, state, summary string) {
+	logs := ""
+	if state == git.InstanceCheckFailure || state == git.InstanceCheckDeploying {
+		logs = r.buildLogTail(ctx, pei)
+	}
+
+	if err := r.gitProviders.ReportInstanceCheck(ctx, pe, pei, state, summary, logs); err != nil {
+		r.log.Error(err, "unable to report the check of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name, "state", state)
+	}
+
+	if err := r.gitProviders.ReportInstanceDeployment(ctx, pe, pei, state, summary); err != nil {
+		r.log.Error(err, "unable to report the deployment of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name, "state", state)
+	}
+
+	if err := r.updatePullRequestComment(ctx, pe, pei, instancePhaseForCheck[state]); err != nil {
+		r.log.Error(err, "unable to update the pull request comment of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name, "state", state)
+	}
+}
+
+// deactivateDeployment marks the deployment of the instance as inactive after it was stopped or deleted
+func (r *PreviewEnvironmentInstanceReconciler) deactivateDeployment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) {
+	if err := r.gitProviders.DeactivateInstanceDeployment(ctx, pe, pei); err != nil {
+		r.log.Error(err, "unable to deactivate the deployment of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+	}
+}
+
+// buildLogTail returns the last lines of the logs of the build job, empty if they are not available
+func (r *PreviewEnvironmentInstanceReconciler) buildLogTail(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) string {
+	if r.Clientset == nil {
+		return ""
+	}
+
+	logs, err := kubeclient.BuildLogTail(ctx, r.Client, r.Clientset, pei, buildLogTailLines)
+	if err != nil {
+		r.log.Error(err, "unable to load the logs of the build job", "namespace", pei.Namespace, "name", pei.Name)
+		return ""
+	}
+	return logs
+}
+
+// rebuildInstance builds and deploys the current commit again, e.g. after the rebuild action of the check was used
+// the job of the previous build has the same name as the new one, the instance only gets pending once that job is gone
+// returns false while the previous job is still being deleted, the job watch triggers the next attempt
+func (r *PreviewEnvironmentInstanceReconciler) rebuildInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, error) {
+	commitHash := pei.Spec.InstanceGitSettings.CommitHash
+
+	var jobs kbatch.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(pei.Namespace), client.MatchingLabels{
+		buildJobInstanceLabel: pei.Name,
+		buildJobCommitLabel:   commitHash,
+	}); err != nil {
+		return false, err
+	}
+
+	if len(jobs.Items) > 0 {
+		for _, job := range jobs.Items {
+			if !job.DeletionTimestamp.IsZero() {
+				continue
+			}
+
+			r.log.Info("Deleting the previous build job before the rebuild", "namespace", pei.Namespace, "name", pei.Name, "job", job.Name)
+			if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	r.log.Info("rebuilding the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+
+	annotations := pei.GetAnnotations()
+	delete(annotations, coflnetv1alpha1.RebuildRequestedAnnotation)
+	pei.SetAnnotations(annotations)
+	if err := r.Update(ctx, pei); err != nil {
+		return false, err
+	}
+
+	// forget the image of the commit, otherwise the build would be skipped
+	pei.Status.BuiltVersions = slices.DeleteFunc(pei.Status.BuiltVersions, func(v coflnetv1alpha1.BuiltVersion) bool {
+		return v.Tag == commitHash
+	})
+	pei.Status.Build = nil
+
+	if err := r.markPreviewEnvironmentInstanceAsPending(ctx, pei); err != nil {
+		return false, err
+	}
+
+	r.reportCheck(ctx, pe, pei, git.InstanceCheckQueued, fmt.Sprintf("A rebuild of commit %s was requested", commitHash))
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
+)
+
+// updatePullRequestComment updates the comment of the pull request of the instance
+// the comment lists all instances of the pull request in the namespace, one per preview environment of the repository
+// phase is the phase the instance is moving to, its status is not updated yet in every case
+func (r *PreviewEnvironmentInstanceReconciler) updatePullRequestComment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, phase string) error {
+	// branch instances have no pull request to comment on
+	number := pei.Spec.InstanceGitSettings.PullRequestNumber
+	if number == nil {
+		return nil
+	}
+
+	var environments coflnetv1alpha1.PreviewEnvironmentList
+	if err := r.List(ctx, &environments, client.InNamespace(pei.Namespace)); err != nil {
+		return err
+	}
+	environmentsById := make(map[string]*coflnetv1alpha1.PreviewEnvironment, len(environments.Items))
+	for i := range environments.Items {
+		environmentsById[string(environments.Items[i].GetUID())] = &environments.Items[i]
+	}
+
+	var peis coflnetv1alpha1.PreviewEnvironmentInstanceList
+	if err := r.List(ctx, &peis, client.InNamespace(pei.Namespace), client.MatchingLabels{
+		"github-organization": pe.Spec.GitSettings.Organization,
+		"github-repository":   pe.Spec.GitSettings.Repository,
+		"github-identifier":   pei.SafeIdentifier(),
+	}); err != nil {
+		return err
+	}
+
+	type entry struct {
+		environment *coflnetv1alpha1.PreviewEnvironment
+		instance    git.CommentInstance
+	}
+
+	var entries []entry
+	for i := range peis.Items {
+		other := &peis.Items[i]
+		otherNumber := other.Spec.InstanceGitSettings.PullRequestNumber
+		if otherNumber == nil || *otherNumber != *number || !other.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		otherPe, ok := environmentsById[other.GetPreviewEnvironmentId()]
+		if !ok || otherPe.Spec.GitSettings.ProviderOrDefault() != pe.Spec.GitSettings.ProviderOrDefault() {
+			continue
+		}
+
+		otherPhase := other.Status.Phase
+		if other.GetUID() == pei.GetUID() {
+			other, otherPhase = pei, phase
+		}
+		entries = append(entries, entry{environment: otherPe, instance: commentInstance(otherPe, other, otherPhase)})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].environment.GetName() < entries[j].environment.GetName()
+	})
+
+	// the first preview environment with a template decides how the comment looks
+	commentTemplate := ""
+	instances := make([]git.CommentInstance, 0, len(entries))
+	for _, e := range entries {
+		if commentTemplate == "" {
+			commentTemplate = e.environment.CommentTemplate()
+		}
+		instances = append(instances, e.instance)
+	}
+
+	return r.gitProviders.UpdatePullRequestComment(ctx, pe, *number, commentTemplate, instances)
+}
+
+func commentInstance(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, phase string) git.CommentInstance {
+	instance := git.CommentInstance{
+		Environment: pe.Spec.DisplayName,
+		Name:        pei.GetName(),
+		Status:      phase,
+		CommitHash:  pei.Spec.InstanceGitSettings.CommitHash,
+		Url:         pei.Status.PublicFacingUrl,
+	}
+	if instance.Environment == "" {
+		instance.Environment = pe.GetName()
+	}
+	if pei.Spec.InstanceGitSettings.Branch != nil {
+		instance.Branch = *pei.Spec.InstanceGitSettings.Branch
+	}
+	if pei.Status.Build != nil && pei.Status.Build.Duration != nil {
+		instance.BuildDuration = pei.Status.Build.Duration.Round(time.Second).String()
+	}
+	return instance
+}
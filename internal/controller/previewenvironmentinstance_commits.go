@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+const (
+	// commitHashAnnotation records the commit a deployment of an instance runs
+	commitHashAnnotation = "coflnet.com/commit-hash"
+
+	// previousCommitOfLabel marks the deployments and services that keep a previous commit of an instance running
+	previousCommitOfLabel = "coflnet.com/previous-commit-of"
+)
+
+// deployedCommit returns the commit the deployment of the instance runs, empty if it was not deployed yet
+func (r *PreviewEnvironmentInstanceReconciler) deployedCommit(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+	var deployment appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &deployment); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	return deployment.GetAnnotations()[commitHashAnnotation], nil
+}
+
+// deployPreviousCommits keeps the previously deployed commits of the instance running next to the current one
+// previous is the commit the instance ran before this deployment, commits beyond the retention of the preview environment are removed
+func (r *PreviewEnvironmentInstanceReconciler) deployPreviousCommits(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, previous string) error {
+	retained := retainedCommits(pei.Spec.InstanceGitSettings.CommitHash, previous, pei.Status.PreviousCommits, pe.PreviousCommitsOrDefault())
+
+	for _, commitHash := range retained {
+		name := pei.NameForCommit(commitHash)
+
+		deployment := kubernetesDeploymentForCommit(pe, pei, name, commitHash)
+		deployment.Labels = map[string]string{previousCommitOfLabel: pei.GetName()}
+		if err := r.applyPreviousCommitResource(ctx, pei, deployment, &appsv1.Deployment{}); err != nil {
+			return err
+		}
+
+		service := kubernetesServiceForDeployment(pe, pei, name)
+		service.Labels[previousCommitOfLabel] = pei.GetName()
+		if err := r.applyPreviousCommitResource(ctx, pei, service, &corev1.Service{}); err != nil {
+			return err
+		}
+	}
+
+	if err := r.deletePreviousCommits(ctx, pei, retained); err != nil {
+		return err
+	}
+
+	pei.Status.PreviousCommits = []coflnetv1alpha1.PreviousCommit{}
+	for _, commitHash := range retained {
+		pei.Status.PreviousCommits = append(pei.Status.PreviousCommits, coflnetv1alpha1.PreviousCommit{
+			CommitHash: commitHash,
+			Url:        fmt.Sprintf("https://%s%s", pe.Spec.ApplicationSettings.IngressHostname, coflnetv1alpha1.PreviewEnvironmentCommitHttpPath(pe, pei, commitHash)),
+		})
+	}
+	return nil
+}
+
+// applyPreviousCommitResource creates or updates a resource of a previous commit, existing is only used to check if it exists
+func (r *PreviewEnvironmentInstanceReconciler) applyPreviousCommitResource(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, obj, existing client.Object) error {
+	if err := controllerutil.SetControllerReference(pei, obj, r.Scheme); err != nil {
+		return err
+	}
+
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+	if err == nil {
+		r.log.Info("Previous commit already runs, updating", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		return r.Update(ctx, obj)
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	r.log.Info("Keeping previous commit running", "namespace", obj.GetNamespace(), "name", obj.GetName())
+	return r.Create(ctx, obj)
+}
+
+// deletePreviousCommits deletes the deployments and services of the previous commits of the instance that are not retained
+func (r *PreviewEnvironmentInstanceReconciler) deletePreviousCommits(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, retained []string) error {
+	selector := client.MatchingLabels{previousCommitOfLabel: pei.GetName()}
+
+	var deployments appsv1.DeploymentList
+	if err := r.List(ctx, &deployments, client.InNamespace(pei.GetNamespace()), selector); err != nil {
+		return err
+	}
+
+	for _, deployment := range deployments.Items {
+		if slices.Contains(retained, deployment.GetAnnotations()[commitHashAnnotation]) {
+			continue
+		}
+
+		r.log.Info("Deleting previous commit", "namespace", deployment.GetNamespace(), "name", deployment.GetName())
+		if err := r.Delete(ctx, &deployment); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+
+		err := r.Delete(ctx, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      deployment.GetName(),
+				Namespace: deployment.GetNamespace(),
+			},
+		})
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousCommitNames returns the names of the deployments of the previous commits that still run
+func previousCommitNames(pei *coflnetv1alpha1.PreviewEnvironmentInstance) []string {
+	names := []string{}
+	for _, previous := range pei.Status.PreviousCommits {
+		names = append(names, pei.NameForCommit(previous.CommitHash))
+	}
+	return names
+}
+
+// retainedCommits returns the previous commits that keep running, newest first
+// the commit that ran before the current one comes first, followed by the ones that were already kept
+func retainedCommits(current, previous string, kept []coflnetv1alpha1.PreviousCommit, limit int) []string {
+	candidates := []string{previous}
+	for _, k := range kept {
+		candidates = append(candidates, k.CommitHash)
+	}
+
+	retained := []string{}
+	for _, commitHash := range candidates {
+		if len(retained) >= limit {
+			break
+		}
+		if commitHash == "" || commitHash == current || slices.Contains(retained, commitHash) {
+			continue
+		}
+		retained = append(retained, commitHash)
+	}
+	return retained
+}
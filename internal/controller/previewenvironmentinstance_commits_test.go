@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+func TestRetainedCommits(t *testing.T) {
+	kept := func(commits ...string) []coflnetv1alpha1.PreviousCommit {
+		result := []coflnetv1alpha1.PreviousCommit{}
+		for _, c := range commits {
+			result = append(result, coflnetv1alpha1.PreviousCommit{CommitHash: c})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		current  string
+		previous string
+		kept     []coflnetv1alpha1.PreviousCommit
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "first deployment",
+			current:  "c1",
+			limit:    1,
+			expected: []string{},
+		},
+		{
+			name:     "previous commit is kept",
+			current:  "c2",
+			previous: "c1",
+			limit:    1,
+			expected: []string{"c1"},
+		},
+		{
+			name:     "retention disabled",
+			current:  "c2",
+			previous: "c1",
+			limit:    0,
+			expected: []string{},
+		},
+		{
+			name:     "oldest commit is dropped",
+			current:  "c3",
+			previous: "c2",
+			kept:     kept("c1"),
+			limit:    1,
+			expected: []string{"c2"},
+		},
+		{
+			name:     "newest first",
+			current:  "c4",
+			previous: "c3",
+			kept:     kept("c2", "c1"),
+			limit:    3,
+			expected: []string{"c3", "c2", "c1"},
+		},
+		{
+			// resuming or redeploying the same commit keeps the previous commits as they are
+			name:     "redeploy of the current commit",
+			current:  "c2",
+			previous: "c2",
+			kept:     kept("c1"),
+			limit:    1,
+			expected: []string{"c1"},
+		},
+		{
+			// the current commit runs in the deployment of the instance, not as a previous commit
+			name:     "going back to a kept commit",
+			current:  "c1",
+			previous: "c2",
+			kept:     kept("c1"),
+			limit:    2,
+			expected: []string{"c2"},
+		},
+		{
+			name:     "duplicates are removed",
+			current:  "c3",
+			previous: "c2",
+			kept:     kept("c2", "c1"),
+			limit:    2,
+			expected: []string{"c2", "c1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := retainedCommits(tt.current, tt.previous, tt.kept, tt.limit)
+			if !slices.Equal(actual, tt.expected) {
+				t.Errorf("retainedCommits() = %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// setInstanceCondition sets or updates a condition of the instance
+// the status still has to be written by the caller
+func setInstanceCondition(pei *coflnetv1alpha1.PreviewEnvironmentInstance, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&pei.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pei.GetGeneration(),
+	})
+}
+
+// updateReadyCondition checks if the deployment of the instance is available
+// and updates the ready condition based on that
+func (r *PreviewEnvironmentInstanceReconciler) updateReadyCondition(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, error) {
+	ready, reason, message, err := r.deploymentReadiness(ctx, pei)
+	if err != nil {
+		return false, err
+	}
+
+	status := metav1.ConditionFalse
+	if ready {
+		status = metav1.ConditionTrue
+	}
+
+	existing := meta.FindStatusCondition(pei.Status.Conditions, coflnetv1alpha1.ConditionReady)
+	if existing != nil && existing.Status == status && existing.Reason == reason && existing.Message == message {
+		return ready, nil
+	}
+
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionReady, status, reason, message)
+	pei.Status.ObservedGeneration = pei.GetGeneration()
+	return ready, r.Status().Update(ctx, pei)
+}
+
+// deploymentReadiness returns if the deployment of the instance has available replicas running the current version
+// if that is not the case the reason tries to explain why, e.g. a crashlooping container
+func (r *PreviewEnvironmentInstanceReconciler) deploymentReadiness(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, string, string, error) {
+	var deployment appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return false, "DeploymentMissing", "the deployment of the instance does not exist", nil
+		}
+		return false, "", "", err
+	}
+
+	if deployment.Status.ObservedGeneration >= deployment.GetGeneration() &&
+		deployment.Status.UpdatedReplicas == deployment.Status.Replicas &&
+		deployment.Status.AvailableReplicas > 0 {
+		return true, "DeploymentAvailable", fmt.Sprintf("%d replicas are available", deployment.Status.AvailableReplicas), nil
+	}
+
+	// look at the pods to find out why the deployment is not available
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(pei.GetNamespace()), client.MatchingLabels{"app": pei.GetName()}); err != nil {
+		return false, "", "", err
+	}
+
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil && status.State.Waiting.Reason != "" && status.State.Waiting.Reason != "ContainerCreating" {
+				return false, status.State.Waiting.Reason, status.State.Waiting.Message, nil
+			}
+		}
+	}
+
+	for _, c := range deployment.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse {
+			return false, c.Reason, c.Message, nil
+		}
+	}
+
+	return false, "DeploymentProgressing", "waiting for the deployment to become available", nil
+}
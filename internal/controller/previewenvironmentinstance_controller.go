@@ -21,14 +21,20 @@ import (
 	"fmt"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	kbatch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/events"
 	"github.com/coflnet/pr-env/internal/git"
 	"github.com/coflnet/pr-env/internal/keycloak"
 	"github.com/go-logr/logr"
@@ -39,13 +45,23 @@ type PreviewEnvironmentInstanceReconciler struct {
 	client.Client
 	Scheme         *runtime.Scheme
 	log            logr.Logger
-	githubClient   *git.GithubClient
+	gitProviders   *git.Providers
 	keycloakClient *keycloak.KeycloakClient
+
+	// Clientset is used to read the logs of the build jobs
+	Clientset kubernetes.Interface
 }
 
 // +kubebuilder:rbac:groups=coflnet.coflnet.com,resources=previewenvironmentinstances,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=coflnet.coflnet.com,resources=previewenvironmentinstances/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=coflnet.coflnet.com,resources=previewenvironmentinstances/finalizers,verbs=update
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	r.log = log.FromContext(ctx)
 
@@ -66,6 +82,11 @@ func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, re
 				return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
 			}
 
+			// the preview environment may already be gone, then there is nothing to deactivate
+			if pe, err := r.loadPreviewEnvironmentForInstance(ctx, &pei); err == nil {
+				r.deactivateDeployment(ctx, pe, &pei)
+			}
+
 			// remove the finalizer
 			controllerutil.RemoveFinalizer(&pei, finalizerName)
 			if err := r.Update(ctx, &pei); err != nil {
@@ -91,20 +112,119 @@ func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, re
 		return ctrl.Result{}, err
 	}
 
-	// check if the instance has to be rebuild
-	if pei.Status.Phase == coflnetv1alpha1.InstancePhasePending {
-		err := r.rebuildInstance(ctx, pe, &pei)
+	// expired instances are stopped or deleted
+	deleted, expiresIn, err := r.handleExpiry(ctx, pe, &pei)
+	if err != nil {
+		r.log.Error(err, "unable to handle the expiry of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+	if deleted {
+		return ctrl.Result{}, nil
+	}
+
+	result, err := r.reconcilePhase(ctx, pe, &pei)
+	if expiresIn > 0 && (result.RequeueAfter == 0 || expiresIn < result.RequeueAfter) {
+		result.RequeueAfter = expiresIn
+	}
+	return result, err
+}
+
+// reconcilePhase moves the instance through its phases until it runs the latest commit
+func (r *PreviewEnvironmentInstanceReconciler) reconcilePhase(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (ctrl.Result, error) {
+	// stopped instances keep their service and ingress but do not run any pods
+	if pei.Spec.DesiredPhase == coflnetv1alpha1.InstancePhaseStopped {
+		if pei.Status.Phase == coflnetv1alpha1.InstancePhaseStopped {
+			return ctrl.Result{}, nil
+		}
+
+		if err := r.stopInstance(ctx, pe, pei); err != nil {
+			r.log.Error(err, "unable to stop the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		r.deactivateDeployment(ctx, pe, pei)
+		return ctrl.Result{}, nil
+	}
+
+	// the instance should run again
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseStopped {
+		if err := r.resumeInstance(ctx, pei); err != nil {
+			r.log.Error(err, "unable to resume the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// idle instances only have to be woken up once a request came in
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseIdle {
+		if _, requested := pei.GetAnnotations()[coflnetv1alpha1.WakeRequestedAnnotation]; !requested {
+			return ctrl.Result{}, nil
+		}
+
+		if err := r.wakeInstance(ctx, pei); err != nil {
+			r.log.Error(err, "unable to wake the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// the current commit should be built again
+	if _, requested := pei.GetAnnotations()[coflnetv1alpha1.RebuildRequestedAnnotation]; requested {
+		rebuilding, err := r.rebuildInstance(ctx, pe, pei)
 		if err != nil {
 			r.log.Error(err, "unable to rebuild the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
-			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, &pei)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		if !rebuilding {
+			// the job watch usually triggers the next attempt earlier
+			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// builds of fork pull requests wait until a maintainer approved the commit
+	// new commits are still picked up further down, they have to be approved again
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhasePending && pei.Spec.InstanceGitSettings.CommitHash != "" && pei.NeedsApproval() {
+		r.log.Info("commit of the fork is waiting for approval", "namespace", pei.Namespace, "name", pei.Name, "commit", pei.Spec.InstanceGitSettings.CommitHash)
+		if err := r.markPreviewEnvironmentInstanceWithStatus(ctx, pei, coflnetv1alpha1.InstancePhaseAwaitingApproval); err != nil {
+			r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as awaiting approval", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		r.reportCheck(ctx, pe, pei, git.InstanceCheckAwaitingApproval, fmt.Sprintf("Commit %s comes from a fork, a maintainer has to approve it before it is built", pei.Spec.InstanceGitSettings.CommitHash))
+		return ctrl.Result{}, nil
+	}
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseAwaitingApproval && !pei.NeedsApproval() {
+		r.log.Info("commit of the fork was approved", "namespace", pei.Namespace, "name", pei.Name, "commit", pei.Spec.InstanceGitSettings.CommitHash)
+		if err := r.markPreviewEnvironmentInstanceAsPending(ctx, pei); err != nil {
+			r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as pending", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// check if the instance has to be rebuild
+	// instances without a commit hash get one assigned further down
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhasePending && pei.Spec.InstanceGitSettings.CommitHash != "" {
+		started, err := r.startBuild(ctx, pe, pei)
+		if err != nil {
+			r.log.Error(err, "unable to start the build of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			r.reportCheck(ctx, pe, pei, git.InstanceCheckFailure, fmt.Sprintf("The build could not be started: %s", err))
+			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, pei)
 			if err != nil {
 				r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as failed", "namespace", pei.Namespace, "name", pei.Name)
 			}
 			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 		}
-		r.log.Info("instance is being rebuilt", "namespace", pei.Namespace, "name", pei.Name)
 
-		err = r.markPreviewEnvironmentInstanceAsDeploying(ctx, &pei)
+		// the job watch triggers the next reconcile once the build finished
+		if started {
+			r.log.Info("instance is being rebuilt", "namespace", pei.Namespace, "name", pei.Name)
+			r.reportCheck(ctx, pe, pei, git.InstanceCheckBuilding, fmt.Sprintf("Building commit %s", pei.Spec.InstanceGitSettings.CommitHash))
+			return ctrl.Result{}, nil
+		}
+
+		r.reportCheck(ctx, pe, pei, git.InstanceCheckDeploying, fmt.Sprintf("The image of commit %s is already available, deploying it", pei.Spec.InstanceGitSettings.CommitHash))
+
+		err = r.markPreviewEnvironmentInstanceAsDeploying(ctx, pei)
 		if err != nil {
 			r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as deploying", "namespace", pei.Namespace, "name", pei.Name)
 			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
@@ -113,12 +233,42 @@ func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, re
 		return ctrl.Result{}, nil
 	}
 
+	// check if the build job finished
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseBuilding {
+		result, err := r.checkBuild(ctx, pei)
+		if err != nil {
+			r.log.Error(err, "unable to check the build of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+
+		switch result {
+		case buildResultSucceeded:
+			r.reportCheck(ctx, pe, pei, git.InstanceCheckDeploying, fmt.Sprintf("Built commit %s, deploying it", pei.Spec.InstanceGitSettings.CommitHash))
+			err = r.markPreviewEnvironmentInstanceAsDeploying(ctx, pei)
+		case buildResultFailed:
+			r.reportCheck(ctx, pe, pei, git.InstanceCheckFailure, fmt.Sprintf("The build of commit %s failed: %s", pei.Spec.InstanceGitSettings.CommitHash, pei.Status.Build.FailureReason))
+			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, pei)
+		case buildResultMissing:
+			r.log.Info("build job disappeared, starting a new build", "namespace", pei.Namespace, "name", pei.Name)
+			err = r.markPreviewEnvironmentInstanceAsPending(ctx, pei)
+		default:
+			return ctrl.Result{}, nil
+		}
+
+		if err != nil {
+			r.log.Error(err, "unable to update the build status of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		return ctrl.Result{}, nil
+	}
+
 	// check if the instance has to be deployed
 	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseDeploying {
-		err := r.redeployInstance(ctx, pe, &pei)
+		err := r.redeployInstance(ctx, pe, pei)
 		if err != nil {
 			r.log.Error(err, "unable to redeploy the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
-			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, &pei)
+			r.reportCheck(ctx, pe, pei, git.InstanceCheckFailure, fmt.Sprintf("The deployment failed: %s", err))
+			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, pei)
 			if err != nil {
 				r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as failed", "namespace", pei.Namespace, "name", pei.Name)
 			}
@@ -126,27 +276,49 @@ func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, re
 		}
 		r.log.Info("instance was deployed", "namespace", pei.Namespace, "name", pei.Name)
 
-		r.log.Info("updating github pull request", "namespace", pei.Namespace, "name", pei.Name)
-		if err := r.githubClient.UpdatePullRequestAnswer(ctx, pe, &pei); err != nil {
-			r.log.Error(err, "unable to update the pull request", "namespace", pei.Namespace, "name", pei.Name)
-			err = r.markPreviewEnvironmentInstanceAsFailed(ctx, &pei)
-			if err != nil {
-				r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as failed", "namespace", pei.Namespace, "name", pei.Name)
-			}
-			return ctrl.Result{RequeueAfter: time.Minute * 1}, nil
-		}
-
-		err = r.markPreviewEnvironmentInstanceAsRunning(ctx, &pei)
+		// the check, the deployment and the pull request comment are updated once the instance runs
+		err = r.markPreviewEnvironmentInstanceAsRunning(ctx, pei)
 		if err != nil {
 			r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as running", "namespace", pei.Namespace, "name", pei.Name)
 			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 		}
+		r.reportCheck(ctx, pe, pei, git.InstanceCheckSuccess, fmt.Sprintf("Commit %s is available at %s", pei.Spec.InstanceGitSettings.CommitHash, pei.Status.PublicFacingUrl))
 
 		return ctrl.Result{}, nil
 	}
 
+	// check if the running instance is able to serve requests
+	result := ctrl.Result{}
+	if pei.Status.Phase == coflnetv1alpha1.InstancePhaseRunning {
+		ready, err := r.updateReadyCondition(ctx, pei)
+		if err != nil {
+			r.log.Error(err, "unable to update the ready condition", "namespace", pei.Namespace, "name", pei.Name)
+			return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+		}
+		if !ready {
+			result.RequeueAfter = time.Second * 30
+		}
+
+		// instances without traffic are scaled to zero if the preview environment has an idle timeout
+		if ready && tracksActivity(pe) {
+			idle, err := r.checkActivity(ctx, pe, pei)
+			if err != nil {
+				r.log.Error(err, "unable to check the activity of the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
+			}
+
+			if idle {
+				if err := r.scaleToIdle(ctx, pe, pei); err != nil {
+					r.log.Error(err, "unable to scale the PreviewEnvironmentInstance to zero", "namespace", pei.Namespace, "name", pei.Name)
+					return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+				}
+				return ctrl.Result{}, nil
+			}
+			result.RequeueAfter = activityCheckInterval
+		}
+	}
+
 	// refresh the latest commit hash to check if the instance is outdated
-	latestCommitHash, err := r.latestCommitHashForPei(ctx, pe, &pei)
+	latestCommitHash, err := r.latestCommitHashForPei(ctx, pe, pei)
 	if err != nil {
 		r.log.Error(err, "unable to get the latest commit hash", "namespace", pei.Namespace, "name", pei.Name)
 		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
@@ -154,20 +326,21 @@ func (r *PreviewEnvironmentInstanceReconciler) Reconcile(ctx context.Context, re
 
 	if latestCommitHash == pei.Spec.InstanceGitSettings.CommitHash {
 		r.log.Info("instance is up to date", "namespace", pei.Namespace, "name", pei.Name)
-		return ctrl.Result{}, nil
+		return result, nil
 	}
 
 	pei.Spec.InstanceGitSettings.CommitHash = latestCommitHash
-	if err := r.Update(ctx, &pei); err != nil {
+	if err := r.Update(ctx, pei); err != nil {
 		r.log.Error(err, "unable to update the PreviewEnvironmentInstance", "namespace", pei.Namespace, "name", pei.Name)
 		return ctrl.Result{}, err
 	}
 
-	err = r.markPreviewEnvironmentInstanceAsPending(ctx, &pei)
+	err = r.markPreviewEnvironmentInstanceAsPending(ctx, pei)
 	if err != nil {
 		r.log.Error(err, "unable to mark the PreviewEnvironmentInstance as pending", "namespace", pei.Namespace, "name", pei.Name)
 		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 	}
+	r.reportCheck(ctx, pe, pei, git.InstanceCheckQueued, fmt.Sprintf("Commit %s is waiting for its build", latestCommitHash))
 
 	return ctrl.Result{}, nil
 }
@@ -184,41 +357,50 @@ func (r *PreviewEnvironmentInstanceReconciler) markPreviewEnvironmentInstanceAsD
 	return r.markPreviewEnvironmentInstanceWithStatus(ctx, pei, coflnetv1alpha1.InstancePhaseDeploying)
 }
 
-func (r *PreviewEnvironmentInstanceReconciler) markPreviewEnvironmentInstanceAsBuilding(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	return r.markPreviewEnvironmentInstanceWithStatus(ctx, pei, coflnetv1alpha1.InstancePhaseBuilding)
-}
-
 func (r *PreviewEnvironmentInstanceReconciler) markPreviewEnvironmentInstanceAsPending(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
 	return r.markPreviewEnvironmentInstanceWithStatus(ctx, pei, coflnetv1alpha1.InstancePhasePending)
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) markPreviewEnvironmentInstanceWithStatus(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, status string) error {
 	pei.Status.Phase = status
+	pei.Status.ObservedGeneration = pei.GetGeneration()
 	return r.Status().Update(ctx, pei)
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) latestCommitHashForPei(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+	provider, err := r.gitProviders.ForEnvironment(ctx, pe)
+	if err != nil {
+		return "", err
+	}
 
-	// TODO: check if the pei is a branch pei
-	// and update the pull request based on that
+	// branch instances follow the head of their branch
 	if pei.Spec.InstanceGitSettings.PullRequestNumber == nil {
-		return "", fmt.Errorf("not implemented")
+		if pei.Spec.InstanceGitSettings.Branch == nil {
+			return "", fmt.Errorf("instance has neither a pull request nor a branch")
+		}
+		return provider.BranchHeadSha(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.Branch)
 	}
 
-	pr, err := r.githubClient.PullRequestOfPei(ctx, pe, pei)
+	pr, err := provider.PullRequest(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.PullRequestNumber)
 	if err != nil {
 		return "", err
 	}
-	return pr.GetHead().GetSHA(), nil
+	return pr.HeadSha, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *PreviewEnvironmentInstanceReconciler) SetupWithManager(mgr ctrl.Manager, gh *git.GithubClient, kClient *keycloak.KeycloakClient) error {
-	r.githubClient = gh
+func (r *PreviewEnvironmentInstanceReconciler) SetupWithManager(mgr ctrl.Manager, providers *git.Providers, kClient *keycloak.KeycloakClient, bus *events.Bus) error {
+	r.gitProviders = providers
 	r.keycloakClient = kClient
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&coflnetv1alpha1.PreviewEnvironmentInstance{}).
+		Owns(&kbatch.Job{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1.Ingress{}).
+		// webhooks report new commits through the event bus
+		WatchesRawSource(bus.InstanceSource()).
 		Named("previewenvironmentinstance").
 		Complete(r)
 }
@@ -18,67 +18,366 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	kbatch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
 )
 
 var _ = Describe("PreviewEnvironmentInstance Controller", func() {
-	Context("When reconciling a resource", func() {
-		const resourceName = "test-resource"
+	const environmentName = "instance-test-environment"
+	const resourceName = "test-resource"
+	const commit = "0123456789abcdef0123456789abcdef01234567"
 
-		ctx := context.Background()
+	ctx := context.Background()
 
-		typeNamespacedName := types.NamespacedName{
-			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+	typeNamespacedName := types.NamespacedName{
+		Name:      resourceName,
+		Namespace: "default",
+	}
+
+	var provider *fakeProvider
+	var controllerReconciler *PreviewEnvironmentInstanceReconciler
+	var previewenvironment *coflnetv1alpha1.PreviewEnvironment
+
+	// createInstance creates the instance without a commit, the first reconcile assigns the head of the fake repository
+	createInstance := func(gitSettings coflnetv1alpha1.InstanceGitSettings) {
+		resource := &coflnetv1alpha1.PreviewEnvironmentInstance{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      resourceName,
+				Namespace: "default",
+				Labels: map[string]string{
+					"owner":               previewenvironment.GetOwner(),
+					"previewenvironment":  string(previewenvironment.GetUID()),
+					"github-organization": previewenvironment.Spec.GitSettings.Organization,
+					"github-repository":   previewenvironment.Spec.GitSettings.Repository,
+					"github-identifier":   coflnetv1alpha1.SafeIdentifier(gitSettings.BranchOrPullRequestIdentifier()),
+				},
+			},
+			Spec: coflnetv1alpha1.PreviewEnvironmentInstanceSpec{
+				InstanceGitSettings: gitSettings,
+				DesiredPhase:        coflnetv1alpha1.InstancePhaseRunning,
+			},
 		}
-		previewenvironmentinstance := &coflnetv1alpha1.PreviewEnvironmentInstance{}
+		Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+	}
 
-		BeforeEach(func() {
-			By("creating the custom resource for the Kind PreviewEnvironmentInstance")
-			err := k8sClient.Get(ctx, typeNamespacedName, previewenvironmentinstance)
-			if err != nil && errors.IsNotFound(err) {
-				resource := &coflnetv1alpha1.PreviewEnvironmentInstance{
-					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
-					},
-					// TODO(user): Specify other spec details if needed.
-				}
-				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
+	reconcileInstance := func() reconcile.Result {
+		result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+			NamespacedName: typeNamespacedName,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	loadInstance := func() *coflnetv1alpha1.PreviewEnvironmentInstance {
+		pei := &coflnetv1alpha1.PreviewEnvironmentInstance{}
+		Expect(k8sClient.Get(ctx, typeNamespacedName, pei)).To(Succeed())
+		return pei
+	}
+
+	loadBuildJob := func() *kbatch.Job {
+		job := &kbatch.Job{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: buildJobName(loadInstance()), Namespace: "default"}, job)).To(Succeed())
+		return job
+	}
+
+	// startBuild reconciles the new instance until its build job was created
+	startBuild := func() *kbatch.Job {
+		By("assigning the head commit of the branch")
+		reconcileInstance()
+		pei := loadInstance()
+		Expect(pei.Spec.InstanceGitSettings.CommitHash).To(Equal(commit))
+		Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhasePending))
+
+		By("creating the build job")
+		reconcileInstance()
+		pei = loadInstance()
+		Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseBuilding))
+		Expect(pei.Status.Build).NotTo(BeNil())
+		Expect(pei.Status.Build.CommitHash).To(Equal(commit))
+		Expect(provider.lastStatus().State).To(Equal(git.CommitStatusPending))
+
+		job := loadBuildJob()
+		Expect(pei.Status.Build.JobName).To(Equal(job.Name))
+		return job
+	}
+
+	// updateJobStatus changes the status of the build job the way the job controller would
+	updateJobStatus := func(job *kbatch.Job, update func(status *kbatch.JobStatus)) {
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(job), job)).To(Succeed())
+		now := metav1.Now()
+		job.Status.StartTime = &now
+		update(&job.Status)
+		Expect(k8sClient.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	failJob := func(job *kbatch.Job, reason, message string) {
+		updateJobStatus(job, func(status *kbatch.JobStatus) {
+			now := metav1.Now()
+			status.Failed = 1
+			status.Conditions = []kbatch.JobCondition{
+				{Type: kbatch.JobFailureTarget, Status: corev1.ConditionTrue, Reason: reason, Message: message, LastProbeTime: now, LastTransitionTime: now},
+				{Type: kbatch.JobFailed, Status: corev1.ConditionTrue, Reason: reason, Message: message, LastProbeTime: now, LastTransitionTime: now},
 			}
 		})
+	}
 
-		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
-			resource := &coflnetv1alpha1.PreviewEnvironmentInstance{}
-			err := k8sClient.Get(ctx, typeNamespacedName, resource)
-			Expect(err).NotTo(HaveOccurred())
+	BeforeEach(func() {
+		provider = &fakeProvider{}
+		controllerReconciler = &PreviewEnvironmentInstanceReconciler{
+			Client:       k8sClient,
+			Scheme:       k8sClient.Scheme(),
+			gitProviders: fakeProviders(provider),
+		}
+
+		By("creating the preview environment of the instance")
+		previewenvironment = &coflnetv1alpha1.PreviewEnvironment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      environmentName,
+				Namespace: "default",
+				Labels:    map[string]string{"owner": "test-owner"},
+			},
+			Spec: coflnetv1alpha1.PreviewEnvironmentSpec{
+				GitSettings: coflnetv1alpha1.GitSettings{
+					Organization: "coflnet",
+					Repository:   "preview",
+				},
+				ContainerRegistry: &coflnetv1alpha1.ContainerRegistry{
+					Registry:   "registry.example.com",
+					Repository: "previews",
+				},
+				ApplicationSettings: coflnetv1alpha1.ApplicationSettings{
+					IngressHostname: "preview.example.com",
+					Port:            8080,
+				},
+				BuildSettings: coflnetv1alpha1.BuildSettings{
+					BuildAllBranches:     true,
+					BuildAllPullRequests: true,
+				},
+				DisplayName: "Preview",
+				AccessSettings: coflnetv1alpha1.AccessSettings{
+					Users:        []coflnetv1alpha1.UserAccess{},
+					PublicAccess: true,
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, previewenvironment)).To(Succeed())
+	})
 
-			By("Cleanup the specific resource instance PreviewEnvironmentInstance")
-			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+	AfterEach(func() {
+		By("Cleanup the build jobs of the instance")
+		Expect(k8sClient.DeleteAllOf(ctx, &kbatch.Job{},
+			client.InNamespace("default"),
+			client.MatchingLabels{buildJobInstanceLabel: resourceName},
+			client.PropagationPolicy(metav1.DeletePropagationBackground),
+		)).To(Succeed())
+
+		By("Cleanup the specific resource instance PreviewEnvironmentInstance")
+		resource := &coflnetv1alpha1.PreviewEnvironmentInstance{}
+		err := k8sClient.Get(ctx, typeNamespacedName, resource)
+		if err == nil {
+			// the finalizer cleans up deployments that were never created, the instance is removed directly
+			resource.SetFinalizers(nil)
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, resource))).To(Succeed())
+		} else {
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		}
+
+		Expect(k8sClient.Delete(ctx, previewenvironment)).To(Succeed())
+	})
+
+	Context("When building the commit of a branch", func() {
+		BeforeEach(func() {
+			provider.branches = []git.Branch{{Name: "feature", HeadSha: commit}}
+			createInstance(coflnetv1alpha1.InstanceGitSettings{Branch: strPtr("feature")})
 		})
-		It("should successfully reconcile the resource", func() {
-			By("Reconciling the created resource")
-			controllerReconciler := &PreviewEnvironmentInstanceReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
-			}
 
-			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
-				NamespacedName: typeNamespacedName,
+		It("should create a build job for the head of the branch", func() {
+			job := startBuild()
+
+			Expect(job.GetLabels()).To(HaveKeyWithValue(buildJobInstanceLabel, resourceName))
+			Expect(job.GetLabels()).To(HaveKeyWithValue(buildJobCommitLabel, commit))
+			Expect(job.Spec.TTLSecondsAfterFinished).To(HaveValue(BeEquivalentTo(buildJobTTL)))
+			Expect(job.Spec.ActiveDeadlineSeconds).To(HaveValue(BeEquivalentTo(buildTimeout)))
+			Expect(metav1.IsControlledBy(job, loadInstance())).To(BeTrue())
+
+			Expect(job.Spec.Template.Spec.InitContainers).To(BeEmpty())
+			Expect(job.Spec.Template.Spec.Containers).To(HaveLen(1))
+			Expect(job.Spec.Template.Spec.Containers[0].Args).To(ContainElement(
+				"--context=" + provider.CloneUrl("coflnet", "preview", "feature", commit)))
+
+			By("waiting while the job runs")
+			Expect(reconcileInstance()).To(Equal(reconcile.Result{}))
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseBuilding))
+		})
+
+		It("should deploy the commit once the build job succeeded", func() {
+			job := startBuild()
+			updateJobStatus(job, func(status *kbatch.JobStatus) {
+				status.Succeeded = 1
+			})
+
+			reconcileInstance()
+			pei := loadInstance()
+			Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseDeploying))
+			Expect(builtVersionAvailable(pei, commit)).To(BeTrue())
+			Expect(pei.Status.Build.CompletionTime).NotTo(BeNil())
+			Expect(pei.Status.Build.FailureReason).To(BeEmpty())
+
+			condition := meta.FindStatusCondition(pei.Status.Conditions, coflnetv1alpha1.ConditionBuildSucceeded)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+			Expect(condition.Reason).To(Equal("BuildSucceeded"))
+		})
+
+		It("should fail the instance once the build job failed", func() {
+			job := startBuild()
+			failJob(job, "BackoffLimitExceeded", "Job has reached the specified backoff limit")
+
+			reconcileInstance()
+			pei := loadInstance()
+			Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseFailed))
+			Expect(builtVersionAvailable(pei, commit)).To(BeFalse())
+			Expect(pei.Status.Build.FailureReason).To(ContainSubstring("BackoffLimitExceeded"))
+			Expect(provider.lastStatus().State).To(Equal(git.CommitStatusFailure))
+
+			condition := meta.FindStatusCondition(pei.Status.Conditions, coflnetv1alpha1.ConditionBuildSucceeded)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("BuildFailed"))
+		})
+
+		It("should fail the instance once the build timed out", func() {
+			job := startBuild()
+			Expect(job.Spec.ActiveDeadlineSeconds).To(HaveValue(BeEquivalentTo(buildTimeout)))
+			failJob(job, "DeadlineExceeded", "Job was active longer than specified deadline")
+
+			reconcileInstance()
+			pei := loadInstance()
+			Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseFailed))
+			Expect(pei.Status.Build.FailureReason).To(ContainSubstring("DeadlineExceeded"))
+			Expect(provider.lastStatus().State).To(Equal(git.CommitStatusFailure))
+		})
+
+		It("should start a new build once the build job disappeared", func() {
+			job := startBuild()
+			Expect(k8sClient.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))).To(Succeed())
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(job), &kbatch.Job{}))
+			}).Should(BeTrue())
+
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhasePending))
+
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseBuilding))
+			loadBuildJob()
+		})
+
+		It("should delete the previous build job before the commit is built again", func() {
+			job := startBuild()
+			updateJobStatus(job, func(status *kbatch.JobStatus) {
+				status.Succeeded = 1
 			})
-			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseDeploying))
+
+			By("requesting a rebuild")
+			pei := loadInstance()
+			pei.SetAnnotations(map[string]string{coflnetv1alpha1.RebuildRequestedAnnotation: "true"})
+			Expect(k8sClient.Update(ctx, pei)).To(Succeed())
+
+			By("deleting the job of the previous build first")
+			Expect(reconcileInstance().RequeueAfter).To(Equal(5 * time.Second))
+			Eventually(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(job), &kbatch.Job{}))
+			}).Should(BeTrue())
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseDeploying))
+
+			By("queueing the build once the job is gone")
+			reconcileInstance()
+			pei = loadInstance()
+			Expect(pei.Status.Phase).To(Equal(coflnetv1alpha1.InstancePhasePending))
+			Expect(pei.GetAnnotations()).NotTo(HaveKey(coflnetv1alpha1.RebuildRequestedAnnotation))
+			Expect(builtVersionAvailable(pei, commit)).To(BeFalse())
+			Expect(pei.Status.Build).To(BeNil())
+
+			By("building the commit again")
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseBuilding))
+			rebuilt := loadBuildJob()
+			Expect(rebuilt.GetUID()).NotTo(Equal(job.GetUID()))
+		})
+	})
+
+	Context("When building the commit of a fork", func() {
+		const pullRequestNumber = 7
+
+		BeforeEach(func() {
+			provider.pullRequests = []git.PullRequest{{
+				Number:     pullRequestNumber,
+				HeadBranch: "fork-feature",
+				HeadSha:    commit,
+				BaseBranch: "main",
+				Fork:       true,
+			}}
+			createInstance(coflnetv1alpha1.InstanceGitSettings{
+				Branch:            strPtr("fork-feature"),
+				PullRequestNumber: intPtr(pullRequestNumber),
+				Fork:              true,
+			})
+		})
+
+		It("should only build the commit after it was approved", func() {
+			By("assigning the head commit of the pull request")
+			reconcileInstance()
+			Expect(loadInstance().Spec.InstanceGitSettings.CommitHash).To(Equal(commit))
+
+			By("waiting for the approval")
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseAwaitingApproval))
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseAwaitingApproval))
+			jobs := &kbatch.JobList{}
+			Expect(k8sClient.List(ctx, jobs, client.InNamespace("default"), client.MatchingLabels{buildJobInstanceLabel: resourceName})).To(Succeed())
+			Expect(jobs.Items).To(BeEmpty())
+
+			By("approving the commit")
+			pei := loadInstance()
+			pei.Spec.ApprovedCommitHash = commit
+			Expect(k8sClient.Update(ctx, pei)).To(Succeed())
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhasePending))
+
+			By("building the fork without any credentials")
+			reconcileInstance()
+			Expect(loadInstance().Status.Phase).To(Equal(coflnetv1alpha1.InstancePhaseBuilding))
+			podSpec := loadBuildJob().Spec.Template.Spec
+			Expect(podSpec.AutomountServiceAccountToken).To(HaveValue(BeFalse()))
+			Expect(podSpec.InitContainers).To(HaveLen(1))
+			Expect(podSpec.InitContainers[0].Image).To(Equal(kanikoImage))
+			Expect(podSpec.InitContainers[0].Args).To(ContainElements(
+				"--context="+provider.PullRequestCloneUrl("coflnet", "preview", pullRequestNumber, commit),
+				"--no-push",
+			))
+			Expect(podSpec.InitContainers[0].Env).To(BeEmpty())
+			Expect(podSpec.InitContainers[0].VolumeMounts).NotTo(ContainElement(HaveField("Name", kanikoSecret)))
+			Expect(podSpec.Containers).To(HaveLen(1))
+			Expect(podSpec.Containers[0].Image).To(Equal(craneImage))
 		})
 	})
 })
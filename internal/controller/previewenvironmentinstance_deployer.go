@@ -12,8 +12,12 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// minReadySeconds how long a new build has to stay ready before the previous one is replaced
+const minReadySeconds = 10
+
 func (r *PreviewEnvironmentInstanceReconciler) redeployInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
 	if pei.Spec.InstanceGitSettings.CommitHash == "" {
 		r.log.Info("No commit hash available, skip this build", "namespace", pei.Namespace, "name", pei.Name)
@@ -30,18 +34,28 @@ func (r *PreviewEnvironmentInstanceReconciler) redeployInstance(ctx context.Cont
 
 	err = r.setupAuthenticationForInstance(ctx, pe, pei)
 	if err != nil {
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionAuthConfigured, metav1.ConditionFalse, "KeycloakGroupFailed", err.Error())
 		return err
 	}
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionAuthConfigured, metav1.ConditionTrue, "KeycloakGroupConfigured", "the keycloak group of the instance is configured")
 
 	err = r.deployEnvironmentInstance(ctx, pe, pei)
 	if err != nil {
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionDeployed, metav1.ConditionFalse, "DeployFailed", err.Error())
 		return err
 	}
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionDeployed, metav1.ConditionTrue, "ResourcesApplied", fmt.Sprintf("commit %s is deployed", pei.Spec.InstanceGitSettings.CommitHash))
 	return nil
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deployEnvironmentInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	err := r.deployKubernetesDeployment(ctx, pe, pei)
+	// the commit that ran so far stays available next to the new one
+	previous, err := r.deployedCommit(ctx, pei)
+	if err != nil {
+		return err
+	}
+
+	err = r.deployKubernetesDeployment(ctx, pe, pei)
 	if err != nil {
 		return err
 	}
@@ -51,9 +65,15 @@ func (r *PreviewEnvironmentInstanceReconciler) deployEnvironmentInstance(ctx con
 		return err
 	}
 
+	err = r.deployPreviousCommits(ctx, pe, pei, previous)
+	if err != nil {
+		return err
+	}
+
 	err = r.deployAuthenticationProxy(ctx, pe, pei)
 	if err != nil {
 		r.log.Error(err, "Unable to deploy authentication proxy")
+		setInstanceCondition(pei, coflnetv1alpha1.ConditionAuthConfigured, metav1.ConditionFalse, "AuthProxyFailed", err.Error())
 	}
 
 	err = r.deployKubernetesIngress(ctx, pe, pei)
@@ -87,29 +107,73 @@ func (r *PreviewEnvironmentInstanceReconciler) deleteResourcesForPreviewEnvironm
 		r.log.Error(err, "Unable to delete ingress", "namespace", pei.GetNamespace(), "name", pei.GetName())
 	}
 
+	err = r.deletePreviousCommits(ctx, pei, nil)
+	if err != nil {
+		r.log.Error(err, "Unable to delete previous commits", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	}
+
+	err = r.Delete(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pei.NameForWakeService(),
+			Namespace: pei.GetNamespace(),
+		},
+	})
+	if client.IgnoreNotFound(err) != nil {
+		r.log.Error(err, "Unable to delete wake service", "namespace", pei.GetNamespace(), "name", pei.NameForWakeService())
+	}
+
 	return nil
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesDeployment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	image := coflnetv1alpha1.PreviewEnvironmentInstanceContainerName(pe, pei.BranchOrPullRequestIdentifier(), pei.Spec.InstanceGitSettings.CommitHash)
+	deployment := kubernetesDeploymentForCommit(pe, pei, pei.GetName(), pei.Spec.InstanceGitSettings.CommitHash)
+
+	// all resources are owned by the instance, they get garbage collected together with it
+	if err := controllerutil.SetControllerReference(pei, deployment, r.Scheme); err != nil {
+		return err
+	}
+
+	r.log.Info("Check if deployment already exists", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	var kDeployment appsv1.Deployment
+	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &kDeployment)
+	if err == nil {
+		r.log.Info("Deployment already exists, updating", "namespace", pei.GetNamespace(), "name", pei.GetName())
+		err = r.Update(ctx, deployment)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	r.log.Info("Creating deployment", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	return r.Create(ctx, deployment)
+}
+
+// kubernetesDeploymentForCommit returns the deployment that runs the image of the given commit of the instance
+// the commit is recorded in an annotation so the next deployment knows which commit ran before
+func kubernetesDeploymentForCommit(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, name, commitHash string) *appsv1.Deployment {
+	image := coflnetv1alpha1.PreviewEnvironmentInstanceContainerName(pe, pei.SafeIdentifier(), commitHash)
 
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pei.GetName(),
+			Name:      name,
 			Namespace: pei.GetNamespace(),
+			Annotations: map[string]string{
+				commitHashAnnotation: commitHash,
+			},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: int32Ptr(1),
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
-					"app":   pei.GetName(),
+					"app":   name,
 					"owner": pe.GetOwner(),
 				},
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
-						"app":   pei.GetName(),
+						"app":   name,
 						"owner": pe.GetOwner(),
 					},
 				},
@@ -124,13 +188,20 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesDeployment(ctx co
 									Name:          "http",
 								},
 							},
-							Env: envFromPe(pe),
+							Env:            envFromPe(pe),
+							ReadinessProbe: readinessProbeForApplication(pe),
 						},
 					},
 				},
 			},
+			// the pod of the previous build keeps serving until the new one was ready for a while
+			MinReadySeconds: minReadySeconds,
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: intstrPtr(intstr.FromInt(0)),
+					MaxSurge:       intstrPtr(intstr.FromInt(1)),
+				},
 			},
 		},
 	}
@@ -140,20 +211,26 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesDeployment(ctx co
 	// 	deployment.Spec.Template.Spec.Containers[0].Command = strings.Split(*pe.Spec.ApplicationSettings.Command, " ")
 	// }
 
-	r.log.Info("Check if deployment already exists", "namespace", pei.GetNamespace(), "name", pei.GetName())
-	var kDeployment appsv1.Deployment
-	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &kDeployment)
-	if err == nil {
-		r.log.Info("Deployment already exists, updating", "namespace", pei.GetNamespace(), "name", pei.GetName())
-		err = r.Update(ctx, deployment)
-		if err != nil {
-			return err
-		}
-		return nil
+	return deployment
+}
+
+// readinessProbeForApplication checks the health check path of the application, or only its port if there is none
+func readinessProbeForApplication(pe *coflnetv1alpha1.PreviewEnvironment) *corev1.Probe {
+	port := intstr.FromInt(pe.Spec.ApplicationSettings.Port)
+	probe := &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: port},
+		},
+		PeriodSeconds:    5,
+		FailureThreshold: 3,
 	}
 
-	r.log.Info("Creating deployment", "namespace", pei.GetNamespace(), "name", pei.GetName())
-	return r.Create(ctx, deployment)
+	if path := pe.Spec.ApplicationSettings.HealthCheckPath; path != nil && *path != "" {
+		probe.ProbeHandler = corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: *path, Port: port},
+		}
+	}
+	return probe
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deleteKubernetesDeployment(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
@@ -171,9 +248,33 @@ func (r *PreviewEnvironmentInstanceReconciler) deleteKubernetesDeployment(ctx co
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesService(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	service := &corev1.Service{
+	service := kubernetesServiceForDeployment(pe, pei, pei.GetName())
+
+	if err := controllerutil.SetControllerReference(pei, service, r.Scheme); err != nil {
+		return err
+	}
+
+	r.log.Info("Check if service already exists", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	var kService corev1.Service
+	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &kService)
+	if err == nil {
+		r.log.Info("Service already exists, updating", "namespace", pei.GetNamespace(), "name", pei.GetName())
+		err = r.Update(ctx, service)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	r.log.Info("Creating service", "namespace", pei.GetNamespace(), "name", pei.GetName())
+	return r.Create(ctx, service)
+}
+
+// kubernetesServiceForDeployment returns the service in front of the deployment with the given name
+func kubernetesServiceForDeployment(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, name string) *corev1.Service {
+	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pei.GetName(),
+			Name:      name,
 			Namespace: pei.GetNamespace(),
 			Labels: map[string]string{
 				"owner": pe.GetOwner(),
@@ -181,7 +282,7 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesService(ctx conte
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
-				"app": pei.GetName(),
+				"app": name,
 			},
 			Ports: []corev1.ServicePort{
 				{
@@ -191,21 +292,6 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesService(ctx conte
 			},
 		},
 	}
-
-	r.log.Info("Check if service already exists", "namespace", pei.GetNamespace(), "name", pei.GetName())
-	var kService corev1.Service
-	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &kService)
-	if err == nil {
-		r.log.Info("Service already exists, updating", "namespace", pei.GetNamespace(), "name", pei.GetName())
-		err = r.Update(ctx, service)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-
-	r.log.Info("Creating service", "namespace", pei.GetNamespace(), "name", pei.GetName())
-	return r.Create(ctx, service)
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deleteKubernetesService(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
@@ -245,7 +331,7 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxy(ctx con
 
 func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyDeployment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
 
-	path := coflnetv1alpha1.PreviewEnvironmentHttpPath(pe, pei)
+	path := coflnetv1alpha1.PreviewEnvironmentStableHttpPath(pe, pei)
 	redirectUrl := fmt.Sprintf("https://%s%s/oauth2/callback", pe.Spec.ApplicationSettings.IngressHostname, path)
 
 	deployment := &appsv1.Deployment{
@@ -283,6 +369,11 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyDeployme
 									Name:          "http",
 									Protocol:      corev1.ProtocolTCP,
 								},
+								{
+									ContainerPort: authProxyMetricsPort,
+									Name:          "metrics",
+									Protocol:      corev1.ProtocolTCP,
+								},
 							},
 							Env: []corev1.EnvVar{
 								{
@@ -303,6 +394,7 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyDeployme
 								"--auth-logging",
 								"--request-logging",
 								"--http-address=0.0.0.0:4180",
+								fmt.Sprintf("--metrics-address=0.0.0.0:%d", authProxyMetricsPort),
 							},
 						},
 					},
@@ -311,6 +403,10 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyDeployme
 		},
 	}
 
+	if err := controllerutil.SetControllerReference(pei, deployment, r.Scheme); err != nil {
+		return err
+	}
+
 	r.log.Info("Check if deployment already exists", "namespace", pei.Namespace, "name", pei.Name+"-auth-proxy")
 	var kDeployment appsv1.Deployment
 	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.NameForAuthProxy()}, &kDeployment)
@@ -347,10 +443,19 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyService(
 					Port:       4180,
 					TargetPort: intstr.FromInt(4180),
 				},
+				{
+					Name:       "metrics",
+					Port:       authProxyMetricsPort,
+					TargetPort: intstr.FromInt(authProxyMetricsPort),
+				},
 			},
 		},
 	}
 
+	if err := controllerutil.SetControllerReference(pei, service, r.Scheme); err != nil {
+		return err
+	}
+
 	r.log.Info("Check if service already exists", "namespace", pei.GetNamespace(), "name", pei.Name+"-auth-proxy")
 	var kService corev1.Service
 	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.NameForAuthProxy()}, &kService)
@@ -368,7 +473,22 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyService(
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyIngress(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	path := fmt.Sprintf("%s/oauth2", coflnetv1alpha1.PreviewEnvironmentHttpPath(pe, pei))
+	paths := []networkingv1.HTTPIngressPath{}
+	for _, route := range routesForInstance(pe, pei) {
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     fmt.Sprintf("%s/oauth2", route.path),
+			PathType: pathPtr(networkingv1.PathTypePrefix),
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: pei.GetName(),
+					Port: networkingv1.ServiceBackendPort{
+						Number: int32(pe.Spec.ApplicationSettings.Port),
+					},
+				},
+			},
+		})
+	}
+
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      pei.NameForAuthProxy(),
@@ -388,20 +508,7 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyIngress(
 					Host: pe.Spec.ApplicationSettings.IngressHostname,
 					IngressRuleValue: networkingv1.IngressRuleValue{
 						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     path,
-									PathType: pathPtr(networkingv1.PathTypePrefix),
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: pei.GetName(),
-											Port: networkingv1.ServiceBackendPort{
-												Number: int32(pe.Spec.ApplicationSettings.Port),
-											},
-										},
-									},
-								},
-							},
+							Paths: paths,
 						},
 					},
 				},
@@ -415,6 +522,10 @@ func (r *PreviewEnvironmentInstanceReconciler) deployAuthenticationProxyIngress(
 		},
 	}
 
+	if err := controllerutil.SetControllerReference(pei, ingress, r.Scheme); err != nil {
+		return err
+	}
+
 	r.log.Info("Check if ingress already exists", "namespace", pei.GetNamespace(), "name", pei.GetName()+"-auth-proxy")
 	var kIngress networkingv1.Ingress
 	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.NameForAuthProxy()}, &kIngress)
@@ -463,30 +574,65 @@ func (r *PreviewEnvironmentInstanceReconciler) deleteKubernetesAuthProxy(ctx con
 }
 
 func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesIngress(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	path := coflnetv1alpha1.PreviewEnvironmentHttpPath(pe, pei)
-
 	host := pe.Spec.ApplicationSettings.IngressHostname
-	publicEndpoint := fmt.Sprintf("https://%s%s", host, path)
+	publicEndpoint := fmt.Sprintf("https://%s%s", host, coflnetv1alpha1.PreviewEnvironmentStableHttpPath(pe, pei))
+	commitEndpoint := fmt.Sprintf("https://%s%s", host, coflnetv1alpha1.PreviewEnvironmentHttpPath(pe, pei))
+
+	// idle instances have no pods, requests go to the operator which wakes the instance up
+	idle := pei.Status.Phase == coflnetv1alpha1.InstancePhaseIdle && pei.Spec.DesiredPhase != coflnetv1alpha1.InstancePhaseStopped
+
+	// the stable path and the commit path point to the same service
+	// the deployment only switches to the new build once it is healthy
+	paths := []networkingv1.HTTPIngressPath{}
+	for _, route := range routesForInstance(pe, pei) {
+		backend := networkingv1.IngressServiceBackend{
+			Name: route.service,
+			Port: networkingv1.ServiceBackendPort{
+				Number: int32(pe.Spec.ApplicationSettings.Port),
+			},
+		}
+		if idle {
+			backend = networkingv1.IngressServiceBackend{
+				Name: pei.NameForWakeService(),
+				Port: networkingv1.ServiceBackendPort{
+					Number: int32(operatorServicePort()),
+				},
+			}
+		}
+
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     route.path,
+			PathType: pathPtr(networkingv1.PathTypeImplementationSpecific),
+			Backend: networkingv1.IngressBackend{
+				Service: &backend,
+			},
+		})
+	}
+
+	annotations := authProxyIngressAnnotations()
+	annotations["nginx.ingress.kubernetes.io/rewrite-target"] = "/"
+
+	// the wake handler of the operator is behind the same auth proxy as the instance
+	if idle {
+		annotations["nginx.ingress.kubernetes.io/rewrite-target"] = fmt.Sprintf("/wake/%s", pei.GetName())
+		annotations["nginx.ingress.kubernetes.io/upstream-vhost"] = operatorServiceHost()
+	}
+
+	// a stopped instance has no pods, answer all requests with the paused page
+	if pei.Spec.DesiredPhase == coflnetv1alpha1.InstancePhaseStopped {
+		annotations = map[string]string{
+			"nginx.ingress.kubernetes.io/configuration-snippet": fmt.Sprintf(`
+    			  default_type text/html;
+    			  return 503 '%s';
+			`, pausedPage),
+		}
+	}
 
 	ingress := &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      pei.GetName(),
-			Namespace: pei.GetNamespace(),
-			Annotations: map[string]string{
-				"nginx.ingress.kubernetes.io/rewrite-target":        "/",
-				"nginx.ingress.kubernetes.io/auth-response-headers": "Authorization",
-				"nginx.ingress.kubernetes.io/auth-signin":           "https://$host/oauth2/start?rd=$escaped_request_uri",
-				"nginx.ingress.kubernetes.io/proxy-buffer-size":     "512k",
-				"nginx.ingress.kubernetes.io/auth-url":              "https://$host/oauth2/auth",
-				"nginx.ingress.kubernetes.io/configuration-snippet": `
-    				  auth_request_set $name_upstream_1 $upstream_cookie_name_1;
-    				  access_by_lua_block {
-    				    if ngx.var.name_upstream_1 ~= "" then
-    				      ngx.header["Set-Cookie"] = "name_1=" .. ngx.var.name_upstream_1 .. ngx.var.auth_cookie:match("(; .*)")
-    				    end
-    				  }
-				`,
-			},
+			Name:        pei.GetName(),
+			Namespace:   pei.GetNamespace(),
+			Annotations: annotations,
 		},
 		Spec: networkingv1.IngressSpec{
 			Rules: []networkingv1.IngressRule{
@@ -494,20 +640,7 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesIngress(ctx conte
 					Host: pe.Spec.ApplicationSettings.IngressHostname,
 					IngressRuleValue: networkingv1.IngressRuleValue{
 						HTTP: &networkingv1.HTTPIngressRuleValue{
-							Paths: []networkingv1.HTTPIngressPath{
-								{
-									Path:     path,
-									PathType: pathPtr(networkingv1.PathTypeImplementationSpecific),
-									Backend: networkingv1.IngressBackend{
-										Service: &networkingv1.IngressServiceBackend{
-											Name: pei.GetName(),
-											Port: networkingv1.ServiceBackendPort{
-												Number: int32(pe.Spec.ApplicationSettings.Port),
-											},
-										},
-									},
-								},
-							},
+							Paths: paths,
 						},
 					},
 				},
@@ -521,6 +654,10 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesIngress(ctx conte
 		},
 	}
 
+	if err := controllerutil.SetControllerReference(pei, ingress, r.Scheme); err != nil {
+		return err
+	}
+
 	r.log.Info("Check if ingress already exists", "namespace", pei.GetNamespace(), "name", pei.GetName())
 	var kIngress networkingv1.Ingress
 	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.GetName()}, &kIngress)
@@ -539,10 +676,29 @@ func (r *PreviewEnvironmentInstanceReconciler) deployKubernetesIngress(ctx conte
 	}
 
 	pei.Status.PublicFacingUrl = publicEndpoint
-	r.log.Info("Updating the status of the PreviewEnvironmentInstance", "namespace", pei.GetNamespace(), "name", pei.GetName(), "publicFacingUrl", publicEndpoint)
+	pei.Status.CommitUrl = commitEndpoint
+	r.log.Info("Updating the status of the PreviewEnvironmentInstance", "namespace", pei.GetNamespace(), "name", pei.GetName(), "publicFacingUrl", publicEndpoint, "commitUrl", commitEndpoint)
 	return r.Status().Update(ctx, pei)
 }
 
+// authProxyIngressAnnotations lets nginx check every request with the auth proxy of the instance
+func authProxyIngressAnnotations() map[string]string {
+	return map[string]string{
+		"nginx.ingress.kubernetes.io/auth-response-headers": "Authorization",
+		"nginx.ingress.kubernetes.io/auth-signin":           "https://$host/oauth2/start?rd=$escaped_request_uri",
+		"nginx.ingress.kubernetes.io/proxy-buffer-size":     "512k",
+		"nginx.ingress.kubernetes.io/auth-url":              "https://$host/oauth2/auth",
+		"nginx.ingress.kubernetes.io/configuration-snippet": `
+    			  auth_request_set $name_upstream_1 $upstream_cookie_name_1;
+    			  access_by_lua_block {
+    			    if ngx.var.name_upstream_1 ~= "" then
+    			      ngx.header["Set-Cookie"] = "name_1=" .. ngx.var.name_upstream_1 .. ngx.var.auth_cookie:match("(; .*)")
+    			    end
+    			  }
+			`,
+	}
+}
+
 func (r *PreviewEnvironmentInstanceReconciler) deleteKubernetesIngress(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
 	r.log.Info("Deleting ingress", "namespace", pei.GetNamespace(), "name", pei.GetName())
 	err := r.Delete(ctx, &networkingv1.Ingress{
@@ -572,19 +728,54 @@ func envFromPe(pe *coflnetv1alpha1.PreviewEnvironment) []corev1.EnvVar {
 	return res
 }
 
+// instanceRoute is a path of the ingress of an instance and the service behind it
+type instanceRoute struct {
+	path    string
+	service string
+}
+
+// routesForInstance returns the stable path and the path of the current commit, both served by the current deployment
+// previous commits that still run are served by their own service
+func routesForInstance(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) []instanceRoute {
+	routes := []instanceRoute{
+		{path: coflnetv1alpha1.PreviewEnvironmentStableHttpPath(pe, pei), service: pei.GetName()},
+		{path: coflnetv1alpha1.PreviewEnvironmentHttpPath(pe, pei), service: pei.GetName()},
+	}
+	for _, previous := range pei.Status.PreviousCommits {
+		routes = append(routes, instanceRoute{
+			path:    coflnetv1alpha1.PreviewEnvironmentCommitHttpPath(pe, pei, previous.CommitHash),
+			service: pei.NameForCommit(previous.CommitHash),
+		})
+	}
+	return routes
+}
+
 func pathPtr(s networkingv1.PathType) *networkingv1.PathType {
 	return &s
 }
 
+func intstrPtr(v intstr.IntOrString) *intstr.IntOrString {
+	return &v
+}
+
 func int32Ptr(i int) *int32 {
 	i32 := int32(i)
 	return &i32
 }
 
+func int64Ptr(i int) *int64 {
+	i64 := int64(i)
+	return &i64
+}
+
 func strPtr(s string) *string {
 	return &s
 }
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func authProxyOauthClientId() string {
 	return mustReadEnv("AUTH_PROXY_CLIENT_ID")
 }
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// handleExpiry updates the expiry time of the instance and applies the expiry action once it is reached
+// returns true if the instance was deleted and the time until the instance expires
+func (r *PreviewEnvironmentInstanceReconciler) handleExpiry(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, time.Duration, error) {
+	expiresAt := instanceExpiry(pe, pei)
+	if !expiryEqual(pei.Status.ExpiresAt, expiresAt) {
+		pei.Status.ExpiresAt = expiresAt
+		if err := r.Status().Update(ctx, pei); err != nil {
+			return false, 0, err
+		}
+	}
+
+	if expiresAt == nil {
+		return false, 0, nil
+	}
+
+	remaining := time.Until(expiresAt.Time)
+	if remaining > 0 {
+		return false, remaining, nil
+	}
+
+	switch pe.ExpiryAction() {
+	case coflnetv1alpha1.StaleActionStop:
+		if pei.Spec.DesiredPhase == coflnetv1alpha1.InstancePhaseStopped {
+			return false, 0, nil
+		}
+
+		r.log.Info("instance expired, stopping it", "namespace", pei.Namespace, "name", pei.Name, "expiresAt", expiresAt)
+		pei.Spec.DesiredPhase = coflnetv1alpha1.InstancePhaseStopped
+		return false, 0, r.Update(ctx, pei)
+	default:
+		r.log.Info("instance expired, deleting it", "namespace", pei.Namespace, "name", pei.Name, "expiresAt", expiresAt)
+		return true, 0, client.IgnoreNotFound(r.Delete(ctx, pei))
+	}
+}
+
+// instanceExpiry returns the earliest expiry of the policy of the preview environment
+// an extension of a reviewer moves the expiry back, nil if the instance does not expire
+func instanceExpiry(pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) *metav1.Time {
+	policy := pe.Spec.ExpiryPolicy
+	if policy == nil {
+		return nil
+	}
+
+	var expiresAt *time.Time
+	earliest := func(t time.Time) {
+		if expiresAt == nil || t.Before(*expiresAt) {
+			expiresAt = &t
+		}
+	}
+
+	if policy.MaxAge != nil {
+		earliest(pei.GetCreationTimestamp().Add(policy.MaxAge.Duration))
+	}
+
+	if policy.IdleTimeout != nil {
+		earliest(lastActivity(pei).Add(policy.IdleTimeout.Duration))
+	}
+
+	if expiresAt == nil {
+		return nil
+	}
+
+	if pei.Spec.ExtendedUntil != nil && pei.Spec.ExtendedUntil.After(*expiresAt) {
+		return pei.Spec.ExtendedUntil.DeepCopy()
+	}
+
+	// the api server only stores seconds, without truncating every reconcile would see a change
+	result := metav1.NewTime(expiresAt.Truncate(time.Second))
+	return &result
+}
+
+// lastActivity returns the last request to the instance, a pending wake request counts as a request as well
+// instances that were never requested count from their creation
+func lastActivity(pei *coflnetv1alpha1.PreviewEnvironmentInstance) time.Time {
+	last := pei.GetCreationTimestamp().Time
+	if pei.Status.Activity != nil && pei.Status.Activity.LastActivityTime != nil {
+		last = pei.Status.Activity.LastActivityTime.Time
+	}
+
+	if requested, ok := pei.GetAnnotations()[coflnetv1alpha1.WakeRequestedAnnotation]; ok {
+		if t, err := time.Parse(time.RFC3339, requested); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+func expiryEqual(a, b *metav1.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(b)
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+func TestInstanceExpiry(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *metav1.Time {
+		v := metav1.NewTime(created.Add(d))
+		return &v
+	}
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
+	activity := func(d time.Duration) *coflnetv1alpha1.ActivityStatus {
+		return &coflnetv1alpha1.ActivityStatus{LastActivityTime: at(d), RequestCount: 3}
+	}
+
+	tests := []struct {
+		name        string
+		policy      *coflnetv1alpha1.ExpiryPolicy
+		activity    *coflnetv1alpha1.ActivityStatus
+		annotations map[string]string
+		extended    *metav1.Time
+		expected    *metav1.Time
+	}{
+		{
+			name:     "no policy",
+			activity: activity(time.Hour),
+		},
+		{
+			name:   "empty policy",
+			policy: &coflnetv1alpha1.ExpiryPolicy{},
+		},
+		{
+			name:     "max age",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{MaxAge: duration(48 * time.Hour)},
+			expected: at(48 * time.Hour),
+		},
+		{
+			name:     "idle timeout without activity counts from the creation",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			expected: at(time.Hour),
+		},
+		{
+			name:     "idle timeout counts from the last activity",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: activity(10 * time.Hour),
+			expected: at(11 * time.Hour),
+		},
+		{
+			// resuming records the start as activity, an old instance does not expire right away
+			name:     "resumed instance counts from the resume",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: &coflnetv1alpha1.ActivityStatus{LastActivityTime: at(72 * time.Hour)},
+			expected: at(73 * time.Hour),
+		},
+		{
+			name:     "wake request counts as activity",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: activity(time.Hour),
+			annotations: map[string]string{
+				coflnetv1alpha1.WakeRequestedAnnotation: created.Add(5 * time.Hour).Format(time.RFC3339),
+			},
+			expected: at(6 * time.Hour),
+		},
+		{
+			name:     "older wake request than the last activity",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: activity(5 * time.Hour),
+			annotations: map[string]string{
+				coflnetv1alpha1.WakeRequestedAnnotation: created.Add(time.Hour).Format(time.RFC3339),
+			},
+			expected: at(6 * time.Hour),
+		},
+		{
+			name:     "invalid wake request is ignored",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: activity(time.Hour),
+			annotations: map[string]string{
+				coflnetv1alpha1.WakeRequestedAnnotation: "now",
+			},
+			expected: at(2 * time.Hour),
+		},
+		{
+			name:     "earliest of max age and idle timeout",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{MaxAge: duration(24 * time.Hour), IdleTimeout: duration(time.Hour)},
+			activity: activity(time.Hour),
+			expected: at(2 * time.Hour),
+		},
+		{
+			name:     "max age before the idle timeout",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{MaxAge: duration(24 * time.Hour), IdleTimeout: duration(time.Hour)},
+			activity: activity(30 * time.Hour),
+			expected: at(24 * time.Hour),
+		},
+		{
+			// starting an expired instance extends it
+			name:     "extension after the max age",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{MaxAge: duration(24 * time.Hour)},
+			extended: at(50 * time.Hour),
+			expected: at(50 * time.Hour),
+		},
+		{
+			name:     "extension before the expiry is ignored",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{MaxAge: duration(24 * time.Hour)},
+			extended: at(time.Hour),
+			expected: at(24 * time.Hour),
+		},
+		{
+			name:     "extension without a policy",
+			extended: at(50 * time.Hour),
+		},
+		{
+			name:     "expiry is truncated to seconds",
+			policy:   &coflnetv1alpha1.ExpiryPolicy{IdleTimeout: duration(time.Hour)},
+			activity: &coflnetv1alpha1.ActivityStatus{LastActivityTime: at(1500 * time.Millisecond)},
+			expected: at(time.Hour + time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := &coflnetv1alpha1.PreviewEnvironment{
+				Spec: coflnetv1alpha1.PreviewEnvironmentSpec{ExpiryPolicy: tt.policy},
+			}
+			pei := &coflnetv1alpha1.PreviewEnvironmentInstance{
+				ObjectMeta: metav1.ObjectMeta{
+					CreationTimestamp: metav1.NewTime(created),
+					Annotations:       tt.annotations,
+				},
+				Spec:   coflnetv1alpha1.PreviewEnvironmentInstanceSpec{ExtendedUntil: tt.extended},
+				Status: coflnetv1alpha1.PreviewEnvironmentInstanceStatus{Activity: tt.activity},
+			}
+
+			actual := instanceExpiry(pe, pei)
+			if !expiryEqual(actual, tt.expected) {
+				t.Errorf("instanceExpiry() = %v, expected %v", actual, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+const (
+	// authProxyMetricsPort the port the auth proxy exposes its prometheus metrics on
+	authProxyMetricsPort = 44180
+
+	// authProxyRequestsMetric counts every request that passed the auth proxy
+	authProxyRequestsMetric = "oauth2_proxy_requests_total"
+
+	// activityCheckInterval how often running instances with an idle timeout are checked for traffic
+	// every reconcile also looks up the latest commit at the git provider, new commits arrive through the webhooks anyway
+	activityCheckInterval = 10 * time.Minute
+)
+
+var metricsHttpClient = &http.Client{Timeout: 5 * time.Second}
+
+// checkActivity compares the request counter of the auth proxy with the last check
+// returns true if the instance did not get any requests within the idle timeout of the application settings
+func (r *PreviewEnvironmentInstanceReconciler) checkActivity(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (bool, error) {
+	count, err := r.requestCountOfInstance(ctx, pei)
+	if err != nil {
+		return false, err
+	}
+
+	// the counter starts at zero again if the auth proxy got restarted
+	activity := pei.Status.Activity
+	if activity == nil || activity.LastActivityTime == nil || count != activity.RequestCount {
+		now := metav1.Now()
+		pei.Status.Activity = &coflnetv1alpha1.ActivityStatus{
+			LastActivityTime: &now,
+			RequestCount:     count,
+		}
+		return false, r.Status().Update(ctx, pei)
+	}
+
+	if pe.Spec.ApplicationSettings.IdleTimeout == nil {
+		return false, nil
+	}
+	return time.Since(activity.LastActivityTime.Time) > pe.Spec.ApplicationSettings.IdleTimeout.Duration, nil
+}
+
+// tracksActivity returns true if the idle timeout or the expiry policy of the preview environment depend on the traffic of the instances
+func tracksActivity(pe *coflnetv1alpha1.PreviewEnvironment) bool {
+	if pe.Spec.ApplicationSettings.IdleTimeout != nil {
+		return true
+	}
+	return pe.Spec.ExpiryPolicy != nil && pe.Spec.ExpiryPolicy.IdleTimeout != nil
+}
+
+// requestCountOfInstance scrapes the metrics of the auth proxy of the instance
+func (r *PreviewEnvironmentInstanceReconciler) requestCountOfInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) (int64, error) {
+	url := fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/metrics", pei.NameForAuthProxy(), pei.GetNamespace(), authProxyMetricsPort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := metricsHttpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d while loading the metrics of %s", res.StatusCode, pei.NameForAuthProxy())
+	}
+
+	// the counter is split by status code and method, sum up all of them
+	var total float64
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, authProxyRequestsMetric) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return 0, err
+		}
+		total += value
+	}
+
+	return int64(total), scanner.Err()
+}
+
+// scaleToIdle scales the application of the instance and its previous commits to zero
+// the auth proxy keeps running, the ingress forwards requests to the wake handler of the operator
+func (r *PreviewEnvironmentInstanceReconciler) scaleToIdle(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	r.log.Info("Instance is idle, scaling it to zero", "namespace", pei.Namespace, "name", pei.Name)
+
+	for _, name := range append([]string{pei.GetName()}, previousCommitNames(pei)...) {
+		if err := r.scaleDeployment(ctx, pei.GetNamespace(), name, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := r.deployWakeService(ctx, pe, pei); err != nil {
+		return err
+	}
+
+	pei.Status.Phase = coflnetv1alpha1.InstancePhaseIdle
+	pei.Status.ObservedGeneration = pei.GetGeneration()
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionReady, metav1.ConditionFalse, "Idle", "the instance was scaled to zero because it did not get any requests")
+	return r.deployKubernetesIngress(ctx, pe, pei)
+}
+
+// wakeInstance scales an idle instance up again
+func (r *PreviewEnvironmentInstanceReconciler) wakeInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	r.log.Info("Waking up the environment instance", "namespace", pei.Namespace, "name", pei.Name)
+
+	delete(pei.Annotations, coflnetv1alpha1.WakeRequestedAnnotation)
+	if err := r.Update(ctx, pei); err != nil {
+		return err
+	}
+
+	return r.resumeInstance(ctx, pei)
+}
+
+// deployWakeService creates a service that points to the operator
+// ingresses can only reference services in their own namespace
+func (r *PreviewEnvironmentInstanceReconciler) deployWakeService(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pei.NameForWakeService(),
+			Namespace: pei.GetNamespace(),
+			Labels: map[string]string{
+				"owner": pe.GetOwner(),
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: operatorServiceHost(),
+			Ports: []corev1.ServicePort{
+				{
+					Name: "http",
+					Port: int32(operatorServicePort()),
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(pei, service, r.Scheme); err != nil {
+		return err
+	}
+
+	r.log.Info("Check if service already exists", "namespace", pei.GetNamespace(), "name", pei.NameForWakeService())
+	var kService corev1.Service
+	err := r.Get(ctx, client.ObjectKey{Namespace: pei.GetNamespace(), Name: pei.NameForWakeService()}, &kService)
+	if err == nil {
+		r.log.Info("Service already exists, updating", "namespace", pei.GetNamespace(), "name", pei.NameForWakeService())
+		return r.Update(ctx, service)
+	}
+
+	r.log.Info("Creating service", "namespace", pei.GetNamespace(), "name", pei.NameForWakeService())
+	return r.Create(ctx, service)
+}
+
+// operatorServiceHost the cluster internal hostname of the wake listener of the operator
+func operatorServiceHost() string {
+	const defaultHost = "pr-env-wake-service.pr-env-system.svc.cluster.local"
+	h := os.Getenv("OPERATOR_SERVICE_HOST")
+	if h == "" {
+		return defaultHost
+	}
+	return h
+}
+
+func operatorServicePort() int {
+	const defaultPort = 8082
+	p, err := strconv.Atoi(os.Getenv("OPERATOR_SERVICE_PORT"))
+	if err != nil {
+		return defaultPort
+	}
+	return p
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// pausedPage is shown by the ingress while the instance is stopped
+const pausedPage = `<!DOCTYPE html><html><head><title>Preview paused</title></head><body style="font-family:sans-serif;text-align:center;margin-top:10%"><h1>This preview is paused</h1><p>The preview environment was stopped, start it again to continue.</p></body></html>`
+
+// stopInstance scales the deployments of the instance and of its previous commits to zero
+// the service and the ingress are kept, the ingress serves the paused page instead
+func (r *PreviewEnvironmentInstanceReconciler) stopInstance(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	r.log.Info("Stopping the environment instance", "namespace", pei.Namespace, "name", pei.Name)
+
+	for _, name := range append([]string{pei.GetName(), pei.NameForAuthProxy()}, previousCommitNames(pei)...) {
+		if err := r.scaleDeployment(ctx, pei.GetNamespace(), name, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := r.deployKubernetesIngress(ctx, pe, pei); err != nil {
+		return err
+	}
+
+	setInstanceCondition(pei, coflnetv1alpha1.ConditionReady, metav1.ConditionFalse, "Stopped", "the instance is stopped")
+	return r.markPreviewEnvironmentInstanceWithStatus(ctx, pei, coflnetv1alpha1.InstancePhaseStopped)
+}
+
+// resumeInstance starts a stopped or idle instance again
+// the last built image is deployed, a build is only started if the image of the current commit is missing
+func (r *PreviewEnvironmentInstanceReconciler) resumeInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	r.log.Info("Resuming the environment instance", "namespace", pei.Namespace, "name", pei.Name)
+
+	// starting the instance counts as activity, the idle timeouts start from scratch
+	// the request counter of the auth proxy is kept, it did not change while the instance was down
+	now := metav1.Now()
+	activity := &coflnetv1alpha1.ActivityStatus{LastActivityTime: &now}
+	if pei.Status.Activity != nil {
+		activity.RequestCount = pei.Status.Activity.RequestCount
+	}
+	pei.Status.Activity = activity
+
+	if builtVersionAvailable(pei, pei.Spec.InstanceGitSettings.CommitHash) {
+		return r.markPreviewEnvironmentInstanceAsDeploying(ctx, pei)
+	}
+	return r.markPreviewEnvironmentInstanceAsPending(ctx, pei)
+}
+
+// scaleDeployment sets the replicas of the deployment, missing deployments are ignored
+func (r *PreviewEnvironmentInstanceReconciler) scaleDeployment(ctx context.Context, namespace, name string, replicas int) error {
+	var deployment appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if deployment.Spec.Replicas != nil && int(*deployment.Spec.Replicas) == replicas {
+		return nil
+	}
+
+	r.log.Info("Scaling deployment", "namespace", namespace, "name", name, "replicas", replicas)
+	deployment.Spec.Replicas = int32Ptr(replicas)
+	return r.Update(ctx, &deployment)
+}
@@ -0,0 +1,61 @@
+package events
+
+import (
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// bufferSize how many events can wait for the controllers before new ones are dropped
+const bufferSize = 256
+
+// Bus forwards changes that were detected outside of the cluster, e.g. by webhooks, to the controllers
+// the controllers pick the events up through a channel source and reconcile the objects right away
+type Bus struct {
+	log                 logr.Logger
+	previewEnvironments chan event.GenericEvent
+	instances           chan event.GenericEvent
+}
+
+func NewBus(logger logr.Logger) *Bus {
+	return &Bus{
+		log:                 logger,
+		previewEnvironments: make(chan event.GenericEvent, bufferSize),
+		instances:           make(chan event.GenericEvent, bufferSize),
+	}
+}
+
+// EnqueuePreviewEnvironment reconciles the preview environment, e.g. to pick up new pull requests
+func (b *Bus) EnqueuePreviewEnvironment(pe *coflnetv1alpha1.PreviewEnvironment) {
+	b.enqueue(b.previewEnvironments, pe)
+}
+
+// EnqueueInstance reconciles the instance, e.g. to pick up a new commit
+func (b *Bus) EnqueueInstance(pei *coflnetv1alpha1.PreviewEnvironmentInstance) {
+	b.enqueue(b.instances, pei)
+}
+
+// PreviewEnvironmentSource is watched by the preview environment controller
+func (b *Bus) PreviewEnvironmentSource() source.Source {
+	return source.Channel(b.previewEnvironments, &handler.EnqueueRequestForObject{})
+}
+
+// InstanceSource is watched by the preview environment instance controller
+func (b *Bus) InstanceSource() source.Source {
+	return source.Channel(b.instances, &handler.EnqueueRequestForObject{})
+}
+
+// enqueue never blocks, if the controllers do not keep up the periodic reconcile picks the change up later
+// this also happens on replicas that are not the leader, their controllers are not running
+func (b *Bus) enqueue(ch chan event.GenericEvent, obj client.Object) {
+	select {
+	case ch <- event.GenericEvent{Object: obj}:
+		b.log.Info("Enqueued reconcile", "namespace", obj.GetNamespace(), "name", obj.GetName())
+	default:
+		b.log.Info("Event buffer is full, dropping reconcile", "namespace", obj.GetNamespace(), "name", obj.GetName())
+	}
+}
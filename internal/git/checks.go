@@ -0,0 +1,101 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// states of the check that is reported for the commit of an instance
+const (
+	InstanceCheckQueued           = "queued"
+	InstanceCheckAwaitingApproval = "awaiting-approval"
+	InstanceCheckBuilding         = "building"
+	InstanceCheckDeploying        = "deploying"
+	InstanceCheckSuccess          = "success"
+	InstanceCheckFailure          = "failure"
+)
+
+// RebuildAction identifies the check run button that builds and deploys the commit again
+const RebuildAction = "rebuild"
+
+// maxDescriptionLength longer descriptions of commit statuses and deployment statuses are rejected by github
+const maxDescriptionLength = 140
+
+// InstanceCheck describes the state of the preview of a commit
+type InstanceCheck struct {
+	// Name is unique per preview environment, a repository can be used by multiple preview environments
+	Name string
+
+	// ExternalID is the name of the instance, it is sent back when an action of the check is requested
+	ExternalID string
+
+	State      string
+	Summary    string
+	Logs       string
+	DetailsUrl string
+}
+
+// checkRunReporter is implemented by providers that support checks with logs and actions
+// the other providers get a commit status
+type checkRunReporter interface {
+	ReportCheckRun(ctx context.Context, owner, repo, sha string, check InstanceCheck) error
+}
+
+// ReportInstanceCheck reports the state of the current commit of the instance to the git provider
+// that way the preview can be a required check of a pull request
+func (p *Providers) ReportInstanceCheck(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, state, summary, logs string) error {
+	sha := pei.Spec.InstanceGitSettings.CommitHash
+	if sha == "" {
+		return nil
+	}
+
+	provider, err := p.ForEnvironment(ctx, pe)
+	if err != nil {
+		return err
+	}
+
+	check := InstanceCheck{
+		Name:       instanceCheckName(pe),
+		ExternalID: pei.GetName(),
+		State:      state,
+		Summary:    summary,
+		Logs:       logs,
+		DetailsUrl: pei.Status.PublicFacingUrl,
+	}
+
+	owner, repo := pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository
+	if reporter, ok := provider.(checkRunReporter); ok {
+		return reporter.ReportCheckRun(ctx, owner, repo, sha, check)
+	}
+	return provider.SetCommitStatus(ctx, owner, repo, sha, check.commitStatus())
+}
+
+func (c InstanceCheck) commitStatus() CommitStatus {
+	state := CommitStatusPending
+	switch c.State {
+	case InstanceCheckSuccess:
+		state = CommitStatusSuccess
+	case InstanceCheckFailure:
+		state = CommitStatusFailure
+	}
+
+	return CommitStatus{
+		State:       state,
+		Context:     c.Name,
+		Description: truncateDescription(c.Summary),
+		TargetUrl:   c.DetailsUrl,
+	}
+}
+
+func instanceCheckName(pe *coflnetv1alpha1.PreviewEnvironment) string {
+	return fmt.Sprintf("preview-environment/%s", pe.GetName())
+}
+
+func truncateDescription(description string) string {
+	if len(description) > maxDescriptionLength {
+		return description[:maxDescriptionLength-3] + "..."
+	}
+	return description
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// commentMarker identifies the comment of the operator, the git providers do not render it
+const commentMarker = "<!-- pr-env:preview-environments -->"
+
+// DefaultCommentTemplate is used for preview environments without their own comment template
+const DefaultCommentTemplate = `### Preview environments
+
+| Environment | Status | Commit | Build duration | URL |
+| --- | --- | --- | --- | --- |
+{{- range .Instances }}
+| {{ .Environment }} | {{ .Status }} | {{ shortSha .CommitHash }} | {{ if .BuildDuration }}{{ .BuildDuration }}{{ else }}-{{ end }} | {{ if .Url }}{{ .Url }}{{ else }}-{{ end }} |
+{{- end }}
+
+This comment is updated automatically by the Preview Environment Operator.`
+
+// CommentData is passed to the comment template
+type CommentData struct {
+	Owner             string
+	Repository        string
+	PullRequestNumber int
+	Instances         []CommentInstance
+}
+
+// CommentInstance is the state of one instance of the pull request
+type CommentInstance struct {
+	// Environment is the display name of the preview environment of the instance
+	Environment   string
+	Name          string
+	Status        string
+	Branch        string
+	CommitHash    string
+	BuildDuration string
+	Url           string
+}
+
+var commentFuncs = template.FuncMap{
+	"shortSha": func(sha string) string {
+		if len(sha) > 7 {
+			return sha[:7]
+		}
+		return sha
+	},
+}
+
+// ParseCommentTemplate parses the go template of a pull request comment, the default template is used if it is empty
+func ParseCommentTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = DefaultCommentTemplate
+	}
+	return template.New("comment").Funcs(commentFuncs).Option("missingkey=error").Parse(text)
+}
+
+// RenderComment renders the body of the pull request comment, the body starts with the marker of the operator
+func RenderComment(text string, data CommentData) (string, error) {
+	tmpl, err := ParseCommentTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	body.WriteString(commentMarker + "\n")
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(body.String()), nil
+}
+
+// CodeBlock wraps the text in a markdown code block
+// the fence is longer than any run of backticks in the text, otherwise the text could close the block early
+func CodeBlock(text string) string {
+	longest, run := 0, 0
+	for _, c := range text {
+		if c != '`' {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+
+	fence := strings.Repeat("`", max(3, longest+1))
+	return fmt.Sprintf("%s\n%s\n%s", fence, text, fence)
+}
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+)
+
+// states of the deployment of an instance, these match the deployment states of github
+const (
+	DeploymentStateQueued     = "queued"
+	DeploymentStateInProgress = "in_progress"
+	DeploymentStateSuccess    = "success"
+	DeploymentStateFailure    = "failure"
+	DeploymentStateInactive   = "inactive"
+)
+
+// InstanceDeployment describes the deployment of the commit of an instance to its environment
+type InstanceDeployment struct {
+	// Environment is unique per instance, e.g. preview-pr-123
+	Environment string
+
+	State          string
+	Description    string
+	EnvironmentUrl string
+}
+
+// deploymentReporter is implemented by providers that show deployments of pull requests
+type deploymentReporter interface {
+	ReportDeployment(ctx context.Context, owner, repo, sha string, deployment InstanceDeployment) error
+	DeactivateDeployment(ctx context.Context, owner, repo, environment string) error
+}
+
+// ReportInstanceDeployment reports the state of the instance as a deployment of its current commit
+// providers without deployments are skipped, the check of the commit is reported anyway
+func (p *Providers) ReportInstanceDeployment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, checkState, description string) error {
+	sha := pei.Spec.InstanceGitSettings.CommitHash
+	if sha == "" {
+		return nil
+	}
+
+	reporter, err := p.deploymentReporter(ctx, pe)
+	if err != nil || reporter == nil {
+		return err
+	}
+
+	return reporter.ReportDeployment(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, sha, InstanceDeployment{
+		Environment:    InstanceEnvironmentName(pei),
+		State:          deploymentStateForCheck(checkState),
+		Description:    description,
+		EnvironmentUrl: pei.Status.PublicFacingUrl,
+	})
+}
+
+// DeactivateInstanceDeployment marks the deployment of the instance as inactive once the instance is torn down
+func (p *Providers) DeactivateInstanceDeployment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
+	reporter, err := p.deploymentReporter(ctx, pe)
+	if err != nil || reporter == nil {
+		return err
+	}
+
+	return reporter.DeactivateDeployment(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, InstanceEnvironmentName(pei))
+}
+
+func (p *Providers) deploymentReporter(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment) (deploymentReporter, error) {
+	provider, err := p.ForEnvironment(ctx, pe)
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, ok := provider.(deploymentReporter)
+	if !ok {
+		return nil, nil
+	}
+	return reporter, nil
+}
+
+// InstanceEnvironmentName returns the name of the deployment environment of the instance
+// e.g. preview-pr-123 for pull requests and preview-main for branches
+func InstanceEnvironmentName(pei *coflnetv1alpha1.PreviewEnvironmentInstance) string {
+	if pei.Spec.InstanceGitSettings.PullRequestNumber != nil {
+		return fmt.Sprintf("preview-pr-%d", *pei.Spec.InstanceGitSettings.PullRequestNumber)
+	}
+	return fmt.Sprintf("preview-%s", pei.SafeIdentifier())
+}
+
+func deploymentStateForCheck(state string) string {
+	switch state {
+	case InstanceCheckQueued, InstanceCheckAwaitingApproval:
+		return DeploymentStateQueued
+	case InstanceCheckSuccess:
+		return DeploymentStateSuccess
+	case InstanceCheckFailure:
+		return DeploymentStateFailure
+	default:
+		return DeploymentStateInProgress
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
-	coflnetv1alpha "github.com/coflnet/pr-env/api/v1alpha1"
 	"github.com/coflnet/pr-env/internal/keycloak"
 	"github.com/go-logr/logr"
 	"github.com/google/go-github/v66/github"
@@ -20,7 +20,10 @@ var (
 )
 
 func NewGithubClient(logger logr.Logger, keycloak *keycloak.KeycloakClient) (*GithubClient, error) {
-	oauthClient := githubOauthClient()
+	oauthClient, err := githubOauthClient()
+	if err != nil {
+		return nil, err
+	}
 	appClient, err := githubAppClient()
 	if err != nil {
 		return nil, err
@@ -28,25 +31,36 @@ func NewGithubClient(logger logr.Logger, keycloak *keycloak.KeycloakClient) (*Gi
 
 	return &GithubClient{
 		log:            logger,
-		oauthClient:    oauthClient,
+		client:         oauthClient,
 		appClient:      appClient,
 		keycloakClient: keycloak,
-
-		userAppClients: make(map[int]*github.Client),
-		userAppTokens:  make(map[int]*github.InstallationToken),
+		installations:  newInstallationClients(appClient),
 	}, nil
 }
 
-func githubOauthClient() *github.Client {
+func githubOauthClient() (*github.Client, error) {
 	if githubOauthClientInstance != nil {
-		return githubOauthClientInstance
+		return githubOauthClientInstance, nil
 	}
 
+	client, err := newGithubApiClient(newGithubTransport(http.DefaultTransport))
+	if err != nil {
+		return nil, err
+	}
 	if authTokenSet() {
-		return github.NewClient(nil).WithAuthToken(authToken())
+		return client.WithAuthToken(authToken()), nil
 	}
 
-	return github.NewClient(nil)
+	return client, nil
+}
+
+// newGithubApiClient creates a client for github.com or for the github enterprise server configured by GITHUB_API_URL
+func newGithubApiClient(transport http.RoundTripper) (*github.Client, error) {
+	client := github.NewClient(&http.Client{Transport: transport})
+	if githubApiUrl() == "" {
+		return client, nil
+	}
+	return client.WithEnterpriseURLs(githubApiUrl(), githubUploadUrl())
 }
 
 func githubAppClient() (*github.Client, error) {
@@ -57,79 +71,260 @@ func githubAppClient() (*github.Client, error) {
 		return nil, err
 	}
 
-	itr, err := ghinstallation.NewAppsTransport(tr, 1054539, privatePem)
+	appId, err := githubAppId()
+	if err != nil {
+		return nil, err
+	}
+
+	itr, err := ghinstallation.NewAppsTransport(newGithubTransport(tr), appId, privatePem)
 	if err != nil {
 		return nil, err
 	}
 
-	return github.NewClient(&http.Client{Transport: itr}), nil
+	client, err := newGithubApiClient(itr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transport requests the app tokens from the same api as the client
+	itr.BaseURL = strings.TrimSuffix(client.BaseURL.String(), "/")
+	return client, nil
 }
 
 type GithubClient struct {
-	oauthClient    *github.Client
+	// client talks to the api, either with the oauth token or as an installation of the github app
+	client         *github.Client
 	appClient      *github.Client
 	keycloakClient *keycloak.KeycloakClient
 	log            logr.Logger
+	installations  *installationClients
 
-	userAppClients map[int]*github.Client
-	userAppTokens  map[int]*github.InstallationToken
+	// installationId is set if the client acts as an installation of the github app
+	installationId int
 }
 
-func (c *GithubClient) PullRequestsOfRepository(ctx context.Context, owner, repo string) ([]*github.PullRequest, error) {
-	prs, _, err := c.oauthClient.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{})
+// make sure the github client can be used as a provider
+var _ Provider = &GithubClient{}
+
+func (c *GithubClient) PullRequests(ctx context.Context, owner, repo string) ([]PullRequest, error) {
+	prs, err := c.openPullRequests(ctx, owner, repo)
 	if err != nil {
 		return nil, err
 	}
 
-	return prs, nil
+	result := make([]PullRequest, 0, len(prs))
+	for _, pr := range prs {
+		result = append(result, githubPullRequest(pr))
+	}
+	return result, nil
 }
 
-func (c *GithubClient) PullRequestsOfRepositoryAndBranch(ctx context.Context, owner, repo, branch string) ([]*github.PullRequest, error) {
-	prs, _, err := c.oauthClient.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{})
+// openPullRequests lists the open pull requests of all pages
+func (c *GithubClient) openPullRequests(ctx context.Context, owner, repo string) ([]*github.PullRequest, error) {
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: githubPageSize},
+	}
+
+	var result []*github.PullRequest
+	for {
+		prs, res, err := c.client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, prs...)
+
+		if res.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+func (c *GithubClient) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	pr, _, err := c.client.PullRequests.Get(ctx, owner, repo, number)
 	if err != nil {
 		return nil, err
 	}
+	result := githubPullRequest(pr)
+	return &result, nil
+}
 
-	var filteredPrs []*github.PullRequest
-	for _, pr := range prs {
-		if strings.Contains(pr.GetHead().GetRef(), branch) {
-			filteredPrs = append(filteredPrs, pr)
+func (c *GithubClient) Branches(ctx context.Context, owner, repo string) ([]Branch, error) {
+	opts := &github.BranchListOptions{ListOptions: github.ListOptions{PerPage: githubPageSize}}
+
+	var result []Branch
+	for {
+		branches, res, err := c.client.Repositories.ListBranches(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
 		}
+
+		for _, branch := range branches {
+			b := branch.GetName()
+			if b == "" {
+				continue
+			}
+
+			result = append(result, Branch{Name: b, HeadSha: branch.GetCommit().GetSHA()})
+		}
+
+		if res.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = res.NextPage
 	}
+}
 
-	return filteredPrs, nil
+func (c *GithubClient) BranchHeadSha(ctx context.Context, owner, repo, branch string) (string, error) {
+	b, _, err := c.client.Repositories.GetBranch(ctx, owner, repo, branch, 1)
+	if err != nil {
+		return "", err
+	}
+	return b.GetCommit().GetSHA(), nil
 }
 
-func (c *GithubClient) PullRequestOfPei(ctx context.Context, pe *coflnetv1alpha.PreviewEnvironment, pei *coflnetv1alpha.PreviewEnvironmentInstance) (*github.PullRequest, error) {
-	if pei.Spec.InstanceGitSettings.PullRequestNumber == nil {
-		return nil, fmt.Errorf("not implemented")
+func (c *GithubClient) Comments(ctx context.Context, owner, repo string, number int) ([]Comment, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: githubPageSize}}
+
+	var result []Comment
+	for {
+		comments, res, err := c.client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, comment := range comments {
+			result = append(result, Comment{ID: comment.GetID(), Body: comment.GetBody(), CreatedAt: comment.GetCreatedAt().Time})
+		}
+
+		if res.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = res.NextPage
 	}
+}
+
+func (c *GithubClient) PostComment(ctx context.Context, owner, repo string, number int, message string) error {
+	return c.postMessageToPr(ctx, owner, repo, number, message)
+}
 
-	return c.PullRequest(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.PullRequestNumber)
+func (c *GithubClient) UpdateComment(ctx context.Context, owner, repo string, number int, id int64, message string) error {
+	c.log.Info("Updating message of PR", "owner", owner, "repo", repo, "prNr", number, "id", id)
+	_, _, err := c.client.Issues.EditComment(ctx, owner, repo, id, &github.IssueComment{
+		Body: &message,
+	})
+	return err
 }
 
-func (c *GithubClient) PullRequest(ctx context.Context, owner, repo string, number int) (*github.PullRequest, error) {
-	pr, _, err := c.oauthClient.PullRequests.Get(ctx, owner, repo, number)
-	return pr, err
+func (c *GithubClient) DeleteComment(ctx context.Context, owner, repo string, number int, id int64) error {
+	_, err := c.client.Issues.DeleteComment(ctx, owner, repo, id)
+	return err
 }
 
-func (c *GithubClient) BranchesOfRepository(ctx context.Context, owner, repo string) ([]string, error) {
-	branches, _, err := c.oauthClient.Repositories.ListBranches(ctx, owner, repo, &github.BranchListOptions{})
+func (c *GithubClient) SetCommitStatus(ctx context.Context, owner, repo, sha string, status CommitStatus) error {
+	_, _, err := c.client.Repositories.CreateStatus(ctx, owner, repo, sha, &github.RepoStatus{
+		State:       github.String(status.State),
+		Context:     github.String(status.Context),
+		Description: github.String(status.Description),
+		TargetURL:   github.String(status.TargetUrl),
+	})
+	return err
+}
+
+func (c *GithubClient) CloneUrl(owner, repo, branch, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(githubUrl(), "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/heads/%s#%s", host, owner, repo, branch, sha)
+}
+
+func (c *GithubClient) PullRequestCloneUrl(owner, repo string, number int, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(githubUrl(), "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/pull/%d/head#%s", host, owner, repo, number, sha)
+}
+
+// CloneCredentials returns a token of the installation that can only read the repository
+// the dockerfile of the repository can read the token, so the token of the installation or the oauth token are never passed to the build
+// without an installation the repository is cloned anonymously
+func (c *GithubClient) CloneCredentials(ctx context.Context, owner, repo string) (*Credentials, error) {
+	if c.installationId == 0 {
+		return nil, nil
+	}
+
+	token, err := c.installations.RepositoryToken(ctx, c.installationId, repo)
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{Username: "x-access-token", Password: token}, nil
+}
+
+// ForInstallation returns a client that acts as the given installation of the github app
+func (c *GithubClient) ForInstallation(ctx context.Context, installationId int) (*GithubClient, error) {
+	client, err := c.installations.Get(ctx, installationId)
 	if err != nil {
 		return nil, err
 	}
 
-	var branchNames []string
-	for _, branch := range branches {
-		b := branch.GetName()
-		if b == "" {
-			continue
+	installationClient := *c
+	installationClient.client = client
+	installationClient.installationId = installationId
+	return &installationClient, nil
+}
+
+// ForOwner returns a client that acts as the github app installation of the user
+// falls back to the oauth token if the user has not installed the app and a token is configured
+func (c *GithubClient) ForOwner(ctx context.Context, userId string) (*GithubClient, error) {
+	installationId, err := c.keycloakClient.GithubInstallationIdForUser(ctx, userId)
+	if err != nil {
+		if _, ok := err.(keycloak.InstallationIdDoesNotExistError); ok && authTokenSet() {
+			return c, nil
 		}
+		return nil, err
+	}
 
-		branchNames = append(branchNames, b)
+	return c.ForInstallation(ctx, installationId)
+}
+
+func githubPullRequest(pr *github.PullRequest) PullRequest {
+	labels := make([]string, 0, len(pr.Labels))
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
 	}
 
-	return branchNames, nil
+	return PullRequest{
+		Number:     pr.GetNumber(),
+		Title:      pr.GetTitle(),
+		HeadBranch: pr.GetHead().GetRef(),
+		HeadSha:    pr.GetHead().GetSHA(),
+		BaseBranch: pr.GetBase().GetRef(),
+		Author:     pr.GetUser().GetLogin(),
+		Draft:      pr.GetDraft(),
+		Labels:     labels,
+		Fork:       pr.GetHead().GetRepo().GetID() != pr.GetBase().GetRepo().GetID(),
+	}
+}
+
+// githubUrl returns the web url of github, it is used to clone the repositories
+func githubUrl() string {
+	v := os.Getenv("GITHUB_URL")
+	if v == "" {
+		return "https://github.com"
+	}
+	return strings.TrimSuffix(v, "/")
+}
+
+// githubApiUrl returns the api url of a github enterprise server, empty for github.com
+// e.g. https://github.example.com/api/v3/
+func githubApiUrl() string {
+	return os.Getenv("GITHUB_API_URL")
+}
+
+// githubUploadUrl returns the upload url of a github enterprise server, defaults to the api url
+func githubUploadUrl() string {
+	v := os.Getenv("GITHUB_UPLOAD_URL")
+	if v == "" {
+		return githubApiUrl()
+	}
+	return v
 }
 
 func authToken() string {
@@ -140,6 +335,15 @@ func authTokenSet() bool {
 	return authToken() != ""
 }
 
+// githubAppId returns the id of the github app, defaults to the app of the hosted operator
+func githubAppId() (int64, error) {
+	v := os.Getenv("GITHUB_APP_ID")
+	if v == "" {
+		return 1054539, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
 func githubAppPrivateKeyPath() string {
 	v := os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH")
 	if v == "" {
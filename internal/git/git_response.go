@@ -3,77 +3,103 @@ package git
 import (
 	"context"
 	"fmt"
-	"time"
+	"strings"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
 	"github.com/google/go-github/v66/github"
 )
 
-func (c *GithubClient) UpdatePullRequestAnswer(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance) error {
-	// then we have to figure out what the answer should be
-	message := c.messageForPrForPei(pei)
-
-	// message already exists
-	doesMessageAlreadyExists := c.pullRequestAlreadyHasMessage(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.PullRequestNumber, message)
-	if doesMessageAlreadyExists {
-		c.log.Info("Message already exists", "owner", pe.Spec.GitSettings.Organization, "repo", pe.Spec.GitSettings.Repository, "prNr", pei.Spec.InstanceGitSettings.PullRequestNumber)
-		return nil
+// UpdatePullRequestComment creates or edits the comment of the operator in the pull request
+// there is one comment per pull request, it is found by its hidden marker and shows all instances of the pull request
+func (p *Providers) UpdatePullRequestComment(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, number int, commentTemplate string, instances []CommentInstance) error {
+	provider, err := p.ForEnvironment(ctx, pe)
+	if err != nil {
+		return err
 	}
 
-	// then we have to update the pull request with the answer
-	return c.postMessageToPr(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.PullRequestNumber, message)
-}
-
-func (c *GithubClient) messageForPrForPei(pei *coflnetv1alpha1.PreviewEnvironmentInstance) string {
-	return fmt.Sprintf(`
-Hello! This is an automated message from the Preview Environment Operator.
-We have detected that there should be a new preview environment for the branch %s.
+	owner, repo := pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository
+	message, err := RenderComment(commentTemplate, CommentData{
+		Owner:             owner,
+		Repository:        repo,
+		PullRequestNumber: number,
+		Instances:         instances,
+	})
+	if err != nil {
+		return err
+	}
 
----
+	comments, err := provider.Comments(ctx, owner, repo, number)
+	if err != nil {
+		return err
+	}
 
-Here you can checkout a preview version for the changes:
-%s
+	for _, comment := range comments {
+		if !strings.Contains(comment.Body, commentMarker) {
+			continue
+		}
 
----
+		if comment.Body == message {
+			p.log.Info("Comment is up to date", "owner", owner, "repo", repo, "prNr", number)
+			return nil
+		}
+		return provider.UpdateComment(ctx, owner, repo, number, comment.ID, message)
+	}
 
-Maybe you can already find some issues here.
-If not even better!
-	`, *pei.Spec.InstanceGitSettings.Branch, pei.Status.PublicFacingUrl)
+	return provider.PostComment(ctx, owner, repo, number, message)
 }
 
 func (c *GithubClient) postMessageToPr(ctx context.Context, owner, repo string, prNr int, message string) error {
 	c.log.Info("Posting message to PR", "owner", owner, "repo", repo, "prNr", prNr, "message", message)
-	_, _, err := c.oauthClient.Issues.CreateComment(ctx, owner, repo, prNr, &github.IssueComment{
+	_, _, err := c.client.Issues.CreateComment(ctx, owner, repo, prNr, &github.IssueComment{
 		Body: &message,
 	})
 	return err
 }
 
-func (c *GithubClient) pullRequestAlreadyHasMessage(ctx context.Context, owner, repo string, prNr int, message string) bool {
-	c.log.Info("Checking if PR already has message", "owner", owner, "repo", repo, "prNr", prNr, "message", message)
-	comments, _, err := c.oauthClient.Issues.ListComments(ctx, owner, repo, prNr, &github.IssueListCommentsOptions{})
+// PostPullRequestCleanupMessage tells the pull request that its preview environment was removed
+// action is the stale action of the preview environment, either delete or stop
+func (p *Providers) PostPullRequestCleanupMessage(ctx context.Context, pe *coflnetv1alpha1.PreviewEnvironment, pei *coflnetv1alpha1.PreviewEnvironmentInstance, action string) error {
+	provider, err := p.ForEnvironment(ctx, pe)
 	if err != nil {
-		c.log.Error(err, "unable to list comments")
-		return false
+		return err
 	}
 
-	for _, comment := range comments {
-		if *comment.Body == message {
-
-			// check if the message should be refershed
-			// TODO: it would be better to check for the last commit hash and update based on that
-			// but for the development version this is enough
-			age := time.Now().Sub(comment.CreatedAt.Time)
-			if age > time.Hour*24 {
-				_, err = c.oauthClient.Issues.DeleteComment(ctx, owner, repo, *comment.ID)
-				if err != nil {
-					c.log.Error(err, "unable to delete comment, but it is outdated")
-					return false
-				}
-				return false
-			}
-			return true
-		}
+	message := fmt.Sprintf(`
+Hello! This is an automated message from the Preview Environment Operator.
+The pull request was closed, the preview environment for the branch %s has been removed.
+	`, *pei.Spec.InstanceGitSettings.Branch)
+	if action == coflnetv1alpha1.StaleActionStop {
+		message = fmt.Sprintf(`
+Hello! This is an automated message from the Preview Environment Operator.
+The pull request was closed, the preview environment for the branch %s has been stopped.
+It will be started again if the pull request gets reopened.
+	`, *pei.Spec.InstanceGitSettings.Branch)
+	}
+
+	return provider.PostComment(ctx, pe.Spec.GitSettings.Organization, pe.Spec.GitSettings.Repository, *pei.Spec.InstanceGitSettings.PullRequestNumber, message)
+}
+
+// ReplyToPullRequest posts a comment to the pull request, used to answer commands
+func (c *GithubClient) ReplyToPullRequest(ctx context.Context, owner, repo string, prNr int, message string) error {
+	return c.postMessageToPr(ctx, owner, repo, prNr, message)
+}
+
+// ReactToComment adds a reaction to a comment of a pull request, e.g. +1 or confused
+func (c *GithubClient) ReactToComment(ctx context.Context, owner, repo string, commentId int64, reaction string) error {
+	_, _, err := c.client.Reactions.CreateIssueCommentReaction(ctx, owner, repo, commentId, reaction)
+	return err
+}
+
+// HasWriteAccess checks if the user is allowed to push to the repository
+func (c *GithubClient) HasWriteAccess(ctx context.Context, owner, repo, user string) (bool, error) {
+	permission, _, err := c.client.Repositories.GetPermissionLevel(ctx, owner, repo, user)
+	if err != nil {
+		return false, err
+	}
+
+	switch permission.GetPermission() {
+	case "admin", "maintain", "write":
+		return true, nil
 	}
-	return false
+	return false, nil
 }
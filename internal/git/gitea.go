@@ -0,0 +1,215 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const giteaPageSize = 50
+
+// GiteaClient talks to the v1 api of a gitea or forgejo instance
+type GiteaClient struct {
+	log    logr.Logger
+	rest   *restClient
+	webUrl string
+}
+
+func NewGiteaClient(logger logr.Logger) *GiteaClient {
+	webUrl := giteaUrl()
+
+	authValue := ""
+	if token := os.Getenv("GITEA_TOKEN"); token != "" {
+		authValue = "token " + token
+	}
+
+	return &GiteaClient{
+		log:    logger,
+		rest:   newRestClient(webUrl+"/api/v1", "Authorization", authValue),
+		webUrl: webUrl,
+	}
+}
+
+type giteaPullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Draft  bool   `json:"draft"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Head giteaPullRequestBranch `json:"head"`
+	Base giteaPullRequestBranch `json:"base"`
+}
+
+type giteaPullRequestBranch struct {
+	Ref    string `json:"ref"`
+	Sha    string `json:"sha"`
+	RepoID int64  `json:"repo_id"`
+}
+
+type giteaBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+type giteaComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *GiteaClient) PullRequests(ctx context.Context, owner, repo string) ([]PullRequest, error) {
+	var result []PullRequest
+	for page := 1; ; page++ {
+		var prs []giteaPullRequest
+		if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/pulls?state=open&limit=%d&page=%d", giteaRepoPath(owner, repo), giteaPageSize, page), nil, &prs); err != nil {
+			return nil, err
+		}
+
+		for _, pr := range prs {
+			result = append(result, pr.toPullRequest())
+		}
+
+		if len(prs) < giteaPageSize {
+			return result, nil
+		}
+	}
+}
+
+func (c *GiteaClient) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	var pr giteaPullRequest
+	if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/pulls/%d", giteaRepoPath(owner, repo), number), nil, &pr); err != nil {
+		return nil, err
+	}
+	result := pr.toPullRequest()
+	return &result, nil
+}
+
+func (c *GiteaClient) Branches(ctx context.Context, owner, repo string) ([]Branch, error) {
+	var result []Branch
+	for page := 1; ; page++ {
+		var branches []giteaBranch
+		if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/branches?limit=%d&page=%d", giteaRepoPath(owner, repo), giteaPageSize, page), nil, &branches); err != nil {
+			return nil, err
+		}
+
+		for _, b := range branches {
+			result = append(result, Branch{Name: b.Name, HeadSha: b.Commit.ID})
+		}
+
+		if len(branches) < giteaPageSize {
+			return result, nil
+		}
+	}
+}
+
+func (c *GiteaClient) BranchHeadSha(ctx context.Context, owner, repo, branch string) (string, error) {
+	var b giteaBranch
+	if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/branches/%s", giteaRepoPath(owner, repo), url.PathEscape(branch)), nil, &b); err != nil {
+		return "", err
+	}
+	return b.Commit.ID, nil
+}
+
+func (c *GiteaClient) Comments(ctx context.Context, owner, repo string, number int) ([]Comment, error) {
+	// gitea returns all comments of an issue at once
+	var comments []giteaComment
+	if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/issues/%d/comments", giteaRepoPath(owner, repo), number), nil, &comments); err != nil {
+		return nil, err
+	}
+
+	result := make([]Comment, 0, len(comments))
+	for _, comment := range comments {
+		result = append(result, Comment{ID: comment.ID, Body: comment.Body, CreatedAt: comment.CreatedAt})
+	}
+	return result, nil
+}
+
+func (c *GiteaClient) PostComment(ctx context.Context, owner, repo string, number int, message string) error {
+	c.log.Info("Posting message to PR", "owner", owner, "repo", repo, "prNr", number)
+	_, err := c.rest.do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/comments", giteaRepoPath(owner, repo), number), map[string]string{"body": message}, nil)
+	return err
+}
+
+func (c *GiteaClient) UpdateComment(ctx context.Context, owner, repo string, number int, id int64, message string) error {
+	c.log.Info("Updating message of PR", "owner", owner, "repo", repo, "prNr", number, "id", id)
+	_, err := c.rest.do(ctx, http.MethodPatch, fmt.Sprintf("%s/issues/comments/%d", giteaRepoPath(owner, repo), id), map[string]string{"body": message}, nil)
+	return err
+}
+
+func (c *GiteaClient) DeleteComment(ctx context.Context, owner, repo string, number int, id int64) error {
+	_, err := c.rest.do(ctx, http.MethodDelete, fmt.Sprintf("%s/issues/comments/%d", giteaRepoPath(owner, repo), id), nil, nil)
+	return err
+}
+
+func (c *GiteaClient) SetCommitStatus(ctx context.Context, owner, repo, sha string, status CommitStatus) error {
+	_, err := c.rest.do(ctx, http.MethodPost, fmt.Sprintf("%s/statuses/%s", giteaRepoPath(owner, repo), sha), map[string]string{
+		"state":       status.State,
+		"context":     status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetUrl,
+	}, nil)
+	return err
+}
+
+func (c *GiteaClient) CloneUrl(owner, repo, branch, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(c.webUrl, "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/heads/%s#%s", host, owner, repo, branch, sha)
+}
+
+func (c *GiteaClient) PullRequestCloneUrl(owner, repo string, number int, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(c.webUrl, "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/pull/%d/head#%s", host, owner, repo, number, sha)
+}
+
+func (c *GiteaClient) CloneCredentials(ctx context.Context, owner, repo string) (*Credentials, error) {
+	token := os.Getenv("GITEA_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	// gitea accepts any username together with an access token
+	return &Credentials{Username: "token", Password: token}, nil
+}
+
+func (pr giteaPullRequest) toPullRequest() PullRequest {
+	labels := make([]string, 0, len(pr.Labels))
+	for _, l := range pr.Labels {
+		labels = append(labels, l.Name)
+	}
+
+	return PullRequest{
+		Number:     pr.Number,
+		Title:      pr.Title,
+		HeadBranch: pr.Head.Ref,
+		HeadSha:    pr.Head.Sha,
+		BaseBranch: pr.Base.Ref,
+		Author:     pr.User.Login,
+		Draft:      pr.Draft,
+		Labels:     labels,
+		Fork:       pr.Head.RepoID != pr.Base.RepoID,
+	}
+}
+
+func giteaRepoPath(owner, repo string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(repo))
+}
+
+func giteaUrl() string {
+	return strings.TrimSuffix(os.Getenv("GITEA_URL"), "/")
+}
+
+// giteaConfigured gitea has no public default instance, it is only available if the url is set
+func giteaConfigured() bool {
+	return giteaUrl() != ""
+}
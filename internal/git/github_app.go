@@ -3,7 +3,6 @@ package git
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/google/go-github/v66/github"
 )
@@ -28,37 +27,14 @@ func (g *GithubClient) ListInstallations(ctx context.Context) error {
 }
 
 // apiClientForInstallation returns a github client for a specific installation
-// if no apiClient for the installation exists a new one is getting created
+// the clients are cached until their token is about to expire
 func (g *GithubClient) apiClientForInstallation(ctx context.Context, installationId int) (*github.Client, error) {
-	val, ok := g.userAppClients[installationId]
-	token, _ := g.userAppTokens[installationId]
-
-	// check if a applient is already created for the installation
-	if ok {
-
-		// if the token is expired, delete the client and token
-		// and create a new one
-		if token.ExpiresAt.After(time.Now()) {
-			delete(g.userAppClients, installationId)
-			delete(g.userAppTokens, installationId)
-			return g.apiClientForInstallation(ctx, installationId)
-		}
-
-		return val, nil
-	}
-
-	// create a new client for the installation
-	token, _, err := g.appClient.Apps.CreateInstallationToken(ctx, int64(installationId), nil)
-	if err != nil {
-		return nil, err
-	}
-	client := github.NewClient(nil).WithAuthToken(token.GetToken())
-
-	// store the client and token
-	g.userAppClients[installationId] = client
-	g.userAppTokens[installationId] = token
+	return g.installations.Get(ctx, installationId)
+}
 
-	return client, nil
+// ForgetInstallation drops the cached client of an installation that was removed or suspended
+func (g *GithubClient) ForgetInstallation(installationId int) {
+	g.installations.Forget(installationId)
 }
 
 // ListReposOfUser lists all repositories of a user
@@ -70,12 +46,22 @@ func (g *GithubClient) ListReposOfUser(ctx context.Context, installId int) (*git
 		return nil, err
 	}
 
-	repos, _, err := client.Apps.ListRepos(ctx, nil)
-	if err != nil {
-		return nil, err
-	}
+	opts := &github.ListOptions{PerPage: githubPageSize}
+
+	result := &github.ListRepositories{}
+	for {
+		repos, res, err := client.Apps.ListRepos(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.TotalCount = repos.TotalCount
+		result.Repositories = append(result.Repositories, repos.Repositories...)
 
-	return repos, nil
+		if res.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = res.NextPage
+	}
 }
 
 func (g *GithubClient) ConfigureInstallationById(ctx context.Context, installationId int) error {
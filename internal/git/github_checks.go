@@ -0,0 +1,103 @@
+package git
+
+import (
+	"context"
+
+	"github.com/google/go-github/v66/github"
+)
+
+var checkRunTitles = map[string]string{
+	InstanceCheckQueued:           "Waiting for the build",
+	InstanceCheckAwaitingApproval: "Waiting for the approval of a maintainer",
+	InstanceCheckBuilding:         "Building",
+	InstanceCheckDeploying:        "Deploying",
+	InstanceCheckSuccess:          "Preview is available",
+	InstanceCheckFailure:          "Preview failed",
+}
+
+// ReportCheckRun creates or updates the check run of the instance for the commit
+// a new check run is created for every build, so rebuilds show up as new runs
+func (c *GithubClient) ReportCheckRun(ctx context.Context, owner, repo, sha string, check InstanceCheck) error {
+	// check runs can only be created by github apps
+	if c.installationId == 0 {
+		return c.SetCommitStatus(ctx, owner, repo, sha, check.commitStatus())
+	}
+
+	status, conclusion := "in_progress", (*string)(nil)
+	switch check.State {
+	case InstanceCheckQueued, InstanceCheckAwaitingApproval:
+		status = "queued"
+	case InstanceCheckSuccess, InstanceCheckFailure:
+		status = "completed"
+		conclusion = github.String(check.State)
+	}
+
+	output := &github.CheckRunOutput{
+		Title:   github.String(checkRunTitles[check.State]),
+		Summary: github.String(check.Summary),
+	}
+	if check.Logs != "" {
+		output.Text = github.String("Last lines of the build logs:\n" + CodeBlock(check.Logs))
+	}
+
+	var detailsUrl *string
+	if check.DetailsUrl != "" {
+		detailsUrl = github.String(check.DetailsUrl)
+	}
+
+	actions := []*github.CheckRunAction{{
+		Label:       "Rebuild",
+		Description: "Build and deploy the commit again",
+		Identifier:  RebuildAction,
+	}}
+
+	existing, err := c.latestCheckRun(ctx, owner, repo, sha, check)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil || check.State == InstanceCheckQueued || check.State == InstanceCheckAwaitingApproval {
+		c.log.Info("Creating check run", "owner", owner, "repo", repo, "sha", sha, "name", check.Name, "state", check.State)
+		_, _, err = c.client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+			Name:       check.Name,
+			HeadSHA:    sha,
+			ExternalID: github.String(check.ExternalID),
+			Status:     github.String(status),
+			Conclusion: conclusion,
+			DetailsURL: detailsUrl,
+			Output:     output,
+			Actions:    actions,
+		})
+		return err
+	}
+
+	_, _, err = c.client.Checks.UpdateCheckRun(ctx, owner, repo, existing.GetID(), github.UpdateCheckRunOptions{
+		Name:       check.Name,
+		ExternalID: github.String(check.ExternalID),
+		Status:     github.String(status),
+		Conclusion: conclusion,
+		DetailsURL: detailsUrl,
+		Output:     output,
+		Actions:    actions,
+	})
+	return err
+}
+
+// latestCheckRun returns the newest check run of the instance for the commit, nil if there is none
+func (c *GithubClient) latestCheckRun(ctx context.Context, owner, repo, sha string, check InstanceCheck) (*github.CheckRun, error) {
+	runs, _, err := c.client.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, &github.ListCheckRunsOptions{
+		CheckName:   github.String(check.Name),
+		Filter:      github.String("latest"),
+		ListOptions: github.ListOptions{PerPage: githubPageSize},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs.CheckRuns {
+		if run.GetExternalID() == check.ExternalID {
+			return run, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"context"
+
+	"github.com/google/go-github/v66/github"
+)
+
+// ReportDeployment creates the deployment of the commit to the environment of the instance and adds a status to it
+// github shows the latest successful deployment with a "View deployment" button in the pull request
+func (c *GithubClient) ReportDeployment(ctx context.Context, owner, repo, sha string, deployment InstanceDeployment) error {
+	existing, err := c.latestDeployment(ctx, owner, repo, deployment.Environment, sha)
+	if err != nil {
+		return err
+	}
+
+	if existing == nil {
+		c.log.Info("Creating deployment", "owner", owner, "repo", repo, "sha", sha, "environment", deployment.Environment)
+		existing, _, err = c.client.Repositories.CreateDeployment(ctx, owner, repo, &github.DeploymentRequest{
+			Ref:         github.String(sha),
+			Environment: github.String(deployment.Environment),
+			Description: github.String("Preview environment"),
+			// the preview check of the commit would never pass before the deployment exists
+			RequiredContexts:      &[]string{},
+			AutoMerge:             github.Bool(false),
+			TransientEnvironment:  github.Bool(true),
+			ProductionEnvironment: github.Bool(false),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	status := &github.DeploymentStatusRequest{
+		State:       github.String(deployment.State),
+		Description: github.String(truncateDescription(deployment.Description)),
+		// deployments of previous commits become inactive once the new one succeeded
+		AutoInactive: github.Bool(true),
+	}
+	if deployment.EnvironmentUrl != "" {
+		status.EnvironmentURL = github.String(deployment.EnvironmentUrl)
+	}
+
+	_, _, err = c.client.Repositories.CreateDeploymentStatus(ctx, owner, repo, existing.GetID(), status)
+	return err
+}
+
+// DeactivateDeployment marks the latest deployment of the environment as inactive
+func (c *GithubClient) DeactivateDeployment(ctx context.Context, owner, repo, environment string) error {
+	existing, err := c.latestDeployment(ctx, owner, repo, environment, "")
+	if err != nil || existing == nil {
+		return err
+	}
+
+	c.log.Info("Deactivating deployment", "owner", owner, "repo", repo, "environment", environment, "id", existing.GetID())
+	_, _, err = c.client.Repositories.CreateDeploymentStatus(ctx, owner, repo, existing.GetID(), &github.DeploymentStatusRequest{
+		State:       github.String(DeploymentStateInactive),
+		Description: github.String("The preview environment was removed"),
+	})
+	return err
+}
+
+// latestDeployment returns the newest deployment of the environment, optionally of a single commit, nil if there is none
+func (c *GithubClient) latestDeployment(ctx context.Context, owner, repo, environment, sha string) (*github.Deployment, error) {
+	deployments, _, err := c.client.Repositories.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		SHA:         sha,
+		Environment: environment,
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deployments) == 0 {
+		return nil, nil
+	}
+	return deployments[0], nil
+}
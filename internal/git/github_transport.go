@@ -0,0 +1,223 @@
+package git
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// githubPageSize is the maximum page size of the github api
+	githubPageSize = 100
+
+	// maxCachedResponses limits the memory used by the etag cache
+	maxCachedResponses = 2000
+
+	// maxRateLimitWait requests that would have to wait longer for the rate limit to reset fail right away
+	maxRateLimitWait = time.Minute
+
+	// maxRateLimitRetries how often a request is retried after hitting the rate limit
+	maxRateLimitRetries = 3
+)
+
+var (
+	githubRateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pr_env_github_rate_limit_remaining",
+		Help: "Remaining requests of the github api rate limit",
+	}, []string{"resource"})
+
+	githubRateLimitLimit = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pr_env_github_rate_limit_limit",
+		Help: "Requests per hour allowed by the github api rate limit",
+	}, []string{"resource"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(githubRateLimitRemaining, githubRateLimitLimit)
+}
+
+// newGithubTransport wraps the transport with etag caching and rate limit handling
+func newGithubTransport(base http.RoundTripper) http.RoundTripper {
+	return &etagTransport{
+		base:  &rateLimitTransport{base: base},
+		cache: map[string]cachedResponse{},
+	}
+}
+
+type cachedResponse struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// etagTransport sends conditional requests for GET requests that were answered before
+// github does not count responses with 304 Not Modified against the rate limit
+type etagTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	cache map[string]cachedResponse
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	// responses depend on the permissions of the token
+	key := req.URL.String() + "\n" + req.Header.Get("Authorization")
+
+	t.mu.Lock()
+	cached, ok := t.cache[key]
+	t.mu.Unlock()
+
+	if ok {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return cachedResponseFor(req, res, cached), nil
+	}
+
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != http.StatusOK || etag == "" {
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	if len(t.cache) >= maxCachedResponses {
+		// drop a random entry, maps are iterated in random order
+		for k := range t.cache {
+			delete(t.cache, k)
+			break
+		}
+	}
+	t.cache[key] = cachedResponse{etag: etag, header: res.Header.Clone(), body: body}
+	t.mu.Unlock()
+
+	return res, nil
+}
+
+// cachedResponseFor builds the response from the cache, the rate limit headers of the actual response are kept
+func cachedResponseFor(req *http.Request, notModified *http.Response, cached cachedResponse) *http.Response {
+	header := cached.header.Clone()
+	for _, h := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-RateLimit-Resource", "Date"} {
+		if v := notModified.Header.Get(h); v != "" {
+			header.Set(h, v)
+		}
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         notModified.Proto,
+		ProtoMajor:    notModified.ProtoMajor,
+		ProtoMinor:    notModified.ProtoMinor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(cached.body)),
+		ContentLength: int64(len(cached.body)),
+		Request:       req,
+	}
+}
+
+// rateLimitTransport records the rate limit of every response and retries requests that hit the rate limit
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		recordRateLimit(res)
+
+		wait, limited := rateLimitWait(res)
+		if !limited || wait > maxRateLimitWait || attempt >= maxRateLimitRetries {
+			return res, nil
+		}
+
+		// the body has to be sent again
+		retry := req.Clone(req.Context())
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return res, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return res, nil
+			}
+			retry.Body = body
+		}
+		res.Body.Close()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		req = retry
+	}
+}
+
+func recordRateLimit(res *http.Response) {
+	resource := res.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining")); err == nil {
+		githubRateLimitRemaining.WithLabelValues(resource).Set(float64(remaining))
+	}
+	if limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit")); err == nil {
+		githubRateLimitLimit.WithLabelValues(resource).Set(float64(limit))
+	}
+}
+
+// rateLimitWait returns how long to wait before the request can be retried
+// github answers with 403 or 429 for the primary and the secondary rate limit
+func rateLimitWait(res *http.Response) (time.Duration, bool) {
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		return time.Duration(retryAfter) * time.Second, true
+	}
+
+	if res.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0, false
+	}
+
+	reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	wait := time.Until(time.Unix(reset, 0))
+	if wait < 0 {
+		wait = 0
+	}
+	return wait + time.Second, true
+}
@@ -0,0 +1,218 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+const gitlabPageSize = 100
+
+// GitlabClient talks to the v4 api of gitlab.com or a self hosted gitlab
+type GitlabClient struct {
+	log    logr.Logger
+	rest   *restClient
+	webUrl string
+}
+
+func NewGitlabClient(logger logr.Logger) *GitlabClient {
+	webUrl := gitlabUrl()
+	return &GitlabClient{
+		log:    logger,
+		rest:   newRestClient(webUrl+"/api/v4", "PRIVATE-TOKEN", os.Getenv("GITLAB_TOKEN")),
+		webUrl: webUrl,
+	}
+}
+
+type gitlabMergeRequest struct {
+	IID             int      `json:"iid"`
+	Title           string   `json:"title"`
+	SourceBranch    string   `json:"source_branch"`
+	TargetBranch    string   `json:"target_branch"`
+	Sha             string   `json:"sha"`
+	Draft           bool     `json:"draft"`
+	Labels          []string `json:"labels"`
+	SourceProjectID int      `json:"source_project_id"`
+	TargetProjectID int      `json:"target_project_id"`
+	Author          struct {
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+type gitlabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+type gitlabNote struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	System    bool      `json:"system"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (c *GitlabClient) PullRequests(ctx context.Context, owner, repo string) ([]PullRequest, error) {
+	var result []PullRequest
+	for page := 1; ; page++ {
+		var mrs []gitlabMergeRequest
+		res, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests?state=opened&per_page=%d&page=%d", gitlabProjectPath(owner, repo), gitlabPageSize, page), nil, &mrs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, mr := range mrs {
+			result = append(result, mr.toPullRequest())
+		}
+
+		if res.Header.Get("X-Next-Page") == "" {
+			return result, nil
+		}
+	}
+}
+
+func (c *GitlabClient) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	var mr gitlabMergeRequest
+	if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests/%d", gitlabProjectPath(owner, repo), number), nil, &mr); err != nil {
+		return nil, err
+	}
+	pr := mr.toPullRequest()
+	return &pr, nil
+}
+
+func (c *GitlabClient) Branches(ctx context.Context, owner, repo string) ([]Branch, error) {
+	var result []Branch
+	for page := 1; ; page++ {
+		var branches []gitlabBranch
+		res, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/repository/branches?per_page=%d&page=%d", gitlabProjectPath(owner, repo), gitlabPageSize, page), nil, &branches)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range branches {
+			result = append(result, Branch{Name: b.Name, HeadSha: b.Commit.ID})
+		}
+
+		if res.Header.Get("X-Next-Page") == "" {
+			return result, nil
+		}
+	}
+}
+
+func (c *GitlabClient) BranchHeadSha(ctx context.Context, owner, repo, branch string) (string, error) {
+	var b gitlabBranch
+	if _, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/repository/branches/%s", gitlabProjectPath(owner, repo), url.PathEscape(branch)), nil, &b); err != nil {
+		return "", err
+	}
+	return b.Commit.ID, nil
+}
+
+func (c *GitlabClient) Comments(ctx context.Context, owner, repo string, number int) ([]Comment, error) {
+	var result []Comment
+	for page := 1; ; page++ {
+		var notes []gitlabNote
+		res, err := c.rest.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests/%d/notes?per_page=%d&page=%d", gitlabProjectPath(owner, repo), number, gitlabPageSize, page), nil, &notes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range notes {
+			// system notes are generated by gitlab, e.g. for new commits
+			if n.System {
+				continue
+			}
+			result = append(result, Comment{ID: n.ID, Body: n.Body, CreatedAt: n.CreatedAt})
+		}
+
+		if res.Header.Get("X-Next-Page") == "" {
+			return result, nil
+		}
+	}
+}
+
+func (c *GitlabClient) PostComment(ctx context.Context, owner, repo string, number int, message string) error {
+	c.log.Info("Posting message to merge request", "owner", owner, "repo", repo, "mrNr", number)
+	_, err := c.rest.do(ctx, http.MethodPost, fmt.Sprintf("%s/merge_requests/%d/notes", gitlabProjectPath(owner, repo), number), map[string]string{"body": message}, nil)
+	return err
+}
+
+func (c *GitlabClient) UpdateComment(ctx context.Context, owner, repo string, number int, id int64, message string) error {
+	c.log.Info("Updating message of merge request", "owner", owner, "repo", repo, "mrNr", number, "id", id)
+	_, err := c.rest.do(ctx, http.MethodPut, fmt.Sprintf("%s/merge_requests/%d/notes/%d", gitlabProjectPath(owner, repo), number, id), map[string]string{"body": message}, nil)
+	return err
+}
+
+func (c *GitlabClient) DeleteComment(ctx context.Context, owner, repo string, number int, id int64) error {
+	_, err := c.rest.do(ctx, http.MethodDelete, fmt.Sprintf("%s/merge_requests/%d/notes/%d", gitlabProjectPath(owner, repo), number, id), nil, nil)
+	return err
+}
+
+func (c *GitlabClient) SetCommitStatus(ctx context.Context, owner, repo, sha string, status CommitStatus) error {
+	// gitlab calls the states differently and does not know error
+	state := status.State
+	switch state {
+	case CommitStatusFailure, CommitStatusError:
+		state = "failed"
+	}
+
+	_, err := c.rest.do(ctx, http.MethodPost, fmt.Sprintf("%s/statuses/%s", gitlabProjectPath(owner, repo), sha), map[string]string{
+		"state":       state,
+		"name":        status.Context,
+		"description": status.Description,
+		"target_url":  status.TargetUrl,
+	}, nil)
+	return err
+}
+
+func (c *GitlabClient) CloneUrl(owner, repo, branch, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(c.webUrl, "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/heads/%s#%s", host, owner, repo, branch, sha)
+}
+
+func (c *GitlabClient) PullRequestCloneUrl(owner, repo string, number int, sha string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(c.webUrl, "https://"), "http://")
+	return fmt.Sprintf("git://%s/%s/%s.git#refs/merge-requests/%d/head#%s", host, owner, repo, number, sha)
+}
+
+func (c *GitlabClient) CloneCredentials(ctx context.Context, owner, repo string) (*Credentials, error) {
+	token := os.Getenv("GITLAB_TOKEN")
+	if token == "" {
+		return nil, nil
+	}
+	// gitlab accepts any username together with an access token
+	return &Credentials{Username: "oauth2", Password: token}, nil
+}
+
+func (mr gitlabMergeRequest) toPullRequest() PullRequest {
+	return PullRequest{
+		Number:     mr.IID,
+		Title:      mr.Title,
+		HeadBranch: mr.SourceBranch,
+		HeadSha:    mr.Sha,
+		BaseBranch: mr.TargetBranch,
+		Author:     mr.Author.Username,
+		Draft:      mr.Draft,
+		Labels:     mr.Labels,
+		Fork:       mr.SourceProjectID != mr.TargetProjectID,
+	}
+}
+
+// gitlabProjectPath gitlab addresses projects by their url encoded full path, groups can be nested
+func gitlabProjectPath(owner, repo string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+func gitlabUrl() string {
+	v := os.Getenv("GITLAB_URL")
+	if v == "" {
+		return "https://gitlab.com"
+	}
+	return strings.TrimSuffix(v, "/")
+}
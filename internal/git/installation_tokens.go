@@ -0,0 +1,130 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v66/github"
+	"golang.org/x/sync/singleflight"
+)
+
+// tokenRefreshMargin installation tokens are replaced this long before they expire
+// so requests that are already running do not fail with an expired token
+const tokenRefreshMargin = 5 * time.Minute
+
+type installationClient struct {
+	client    *github.Client
+	token     string
+	expiresAt time.Time
+}
+
+// installationClients caches a github client per installation of the github app
+// it is shared by the http server and the controllers, concurrent refreshes of the same installation are coalesced
+type installationClients struct {
+	appClient *github.Client
+
+	mu      sync.RWMutex
+	clients map[int]installationClient
+	group   singleflight.Group
+}
+
+func newInstallationClients(appClient *github.Client) *installationClients {
+	return &installationClients{
+		appClient: appClient,
+		clients:   map[int]installationClient{},
+	}
+}
+
+// Get returns a client for the installation, a new token is created if there is none or it is about to expire
+func (c *installationClients) Get(ctx context.Context, installationId int) (*github.Client, error) {
+	entry, err := c.entry(ctx, installationId)
+	if err != nil {
+		return nil, err
+	}
+	return entry.client, nil
+}
+
+// Token returns the current token of the installation, it is valid for at least the refresh margin
+func (c *installationClients) Token(ctx context.Context, installationId int) (string, time.Time, error) {
+	entry, err := c.entry(ctx, installationId)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return entry.token, entry.expiresAt, nil
+}
+
+// RepositoryToken creates a token of the installation that can only read the contents of one repository
+// the token is handed to builds that run code of the repository, it is not cached so every build gets its own
+func (c *installationClients) RepositoryToken(ctx context.Context, installationId int, repo string) (string, error) {
+	token, _, err := c.appClient.Apps.CreateInstallationToken(ctx, int64(installationId), &github.InstallationTokenOptions{
+		Repositories: []string{repo},
+		Permissions: &github.InstallationPermissions{
+			Contents: github.String("read"),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.GetToken(), nil
+}
+
+func (c *installationClients) entry(ctx context.Context, installationId int) (installationClient, error) {
+	if entry, ok := c.cached(installationId); ok {
+		return entry, nil
+	}
+
+	entry, err, _ := c.group.Do(strconv.Itoa(installationId), func() (interface{}, error) {
+		// another caller might have refreshed the token while this one was waiting
+		if entry, ok := c.cached(installationId); ok {
+			return entry, nil
+		}
+
+		// the token is shared, a cancelled request should not fail the others waiting for it
+		token, _, err := c.appClient.Apps.CreateInstallationToken(context.WithoutCancel(ctx), int64(installationId), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := newGithubApiClient(newGithubTransport(http.DefaultTransport))
+		if err != nil {
+			return nil, err
+		}
+
+		entry := installationClient{
+			client:    client.WithAuthToken(token.GetToken()),
+			token:     token.GetToken(),
+			expiresAt: token.GetExpiresAt().Time,
+		}
+
+		c.mu.Lock()
+		c.clients[installationId] = entry
+		c.mu.Unlock()
+
+		return entry, nil
+	})
+	if err != nil {
+		return installationClient{}, err
+	}
+	return entry.(installationClient), nil
+}
+
+// Forget removes the client of the installation, e.g. after the app was uninstalled
+func (c *installationClients) Forget(installationId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.clients, installationId)
+}
+
+func (c *installationClients) cached(installationId int) (installationClient, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.clients[installationId]
+	if !ok || time.Now().Add(tokenRefreshMargin).After(entry.expiresAt) {
+		return installationClient{}, false
+	}
+	return entry, true
+}
@@ -0,0 +1,150 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	coflnetv1alpha "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/go-logr/logr"
+)
+
+// Provider is implemented by every supported git hosting service
+// owner is the organization, user or group the repository belongs to
+type Provider interface {
+	// PullRequests lists the open pull requests, merge requests for gitlab
+	PullRequests(ctx context.Context, owner, repo string) ([]PullRequest, error)
+
+	// PullRequest loads a single pull request by its number
+	PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error)
+
+	// Branches lists all branches of the repository
+	Branches(ctx context.Context, owner, repo string) ([]Branch, error)
+
+	// BranchHeadSha returns the commit hash the branch points to
+	BranchHeadSha(ctx context.Context, owner, repo, branch string) (string, error)
+
+	// Comments lists the comments of a pull request
+	Comments(ctx context.Context, owner, repo string, number int) ([]Comment, error)
+
+	// PostComment adds a comment to a pull request
+	PostComment(ctx context.Context, owner, repo string, number int, message string) error
+
+	// UpdateComment replaces the body of a comment of a pull request
+	UpdateComment(ctx context.Context, owner, repo string, number int, id int64, message string) error
+
+	// DeleteComment removes a comment of a pull request
+	DeleteComment(ctx context.Context, owner, repo string, number int, id int64) error
+
+	// SetCommitStatus attaches a status to a commit
+	SetCommitStatus(ctx context.Context, owner, repo, sha string, status CommitStatus) error
+
+	// CloneUrl returns the git context of a commit of the branch that is used for the build
+	// the commit is pinned, otherwise a push during the build would end up in the image of the older commit
+	CloneUrl(owner, repo, branch, sha string) string
+
+	// PullRequestCloneUrl returns the git context of a commit of a pull request
+	// used for forks, their branches do not exist in the repository
+	PullRequestCloneUrl(owner, repo string, number int, sha string) string
+
+	// CloneCredentials returns the credentials the build uses to clone the repository
+	// the build runs the dockerfile of the repository and can read them, they should only allow reading the repository
+	// nil if no credentials are configured, only public repositories can be built then
+	CloneCredentials(ctx context.Context, owner, repo string) (*Credentials, error)
+}
+
+// PullRequest is the provider independent representation of a pull or merge request
+type PullRequest struct {
+	Number     int
+	Title      string
+	HeadBranch string
+	HeadSha    string
+	BaseBranch string
+	Author     string
+	Draft      bool
+	Labels     []string
+
+	// Fork is true if the head branch lives in another repository
+	Fork bool
+}
+
+// Credentials are used by the build to clone private repositories
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type Branch struct {
+	Name    string
+	HeadSha string
+}
+
+type Comment struct {
+	ID        int64
+	Body      string
+	CreatedAt time.Time
+}
+
+type CommitStatus struct {
+	State       string
+	Context     string
+	Description string
+	TargetUrl   string
+}
+
+const (
+	CommitStatusPending = "pending"
+	CommitStatusSuccess = "success"
+	CommitStatusFailure = "failure"
+	CommitStatusError   = "error"
+)
+
+// Providers holds the clients of all configured git hosting services
+type Providers struct {
+	log       logr.Logger
+	github    *GithubClient
+	providers map[string]Provider
+}
+
+func NewProviders(logger logr.Logger, github *GithubClient) *Providers {
+	providers := map[string]Provider{
+		coflnetv1alpha.GitProviderGithub: github,
+	}
+
+	// gitlab.com works without any configuration, self hosted instances need the url
+	providers[coflnetv1alpha.GitProviderGitlab] = NewGitlabClient(logger.WithName("gitlab"))
+
+	if giteaConfigured() {
+		providers[coflnetv1alpha.GitProviderGitea] = NewGiteaClient(logger.WithName("gitea"))
+	}
+
+	return &Providers{
+		log:       logger,
+		github:    github,
+		providers: providers,
+	}
+}
+
+// NewStaticProviders returns providers that always use the given clients, e.g. fakes in tests
+// github repositories are not accessed through an app installation then
+func NewStaticProviders(logger logr.Logger, providers map[string]Provider) *Providers {
+	return &Providers{
+		log:       logger,
+		providers: providers,
+	}
+}
+
+// ForEnvironment returns the provider that hosts the repository of the preview environment
+// github repositories are accessed through the github app installation of the owner of the preview environment
+func (p *Providers) ForEnvironment(ctx context.Context, pe *coflnetv1alpha.PreviewEnvironment) (Provider, error) {
+	name := pe.Spec.GitSettings.ProviderOrDefault()
+	if name == coflnetv1alpha.GitProviderGithub && p.github != nil {
+		return p.github.ForOwner(ctx, pe.GetOwner())
+	}
+
+	provider, ok := p.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("git provider %s is not configured", name)
+	}
+	return provider, nil
+}
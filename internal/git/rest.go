@@ -0,0 +1,71 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// restClient is a minimal json client for the apis of gitlab and gitea
+type restClient struct {
+	baseUrl    string
+	authHeader string
+	authValue  string
+	httpClient *http.Client
+}
+
+func newRestClient(baseUrl, authHeader, authValue string) *restClient {
+	return &restClient{
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+		authHeader: authHeader,
+		authValue:  authValue,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends the request and decodes the json response into out if it is not nil
+func (c *restClient) do(ctx context.Context, method, path string, body, out interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.authValue != "" {
+		req.Header.Set(c.authHeader, c.authValue)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return res, fmt.Errorf("%s %s returned %d: %s", method, path, res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
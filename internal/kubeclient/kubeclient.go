@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
@@ -23,6 +24,9 @@ type KubeClient struct {
 	log          logr.Logger
 	kClient      client.Client
 	ownNamespace string
+
+	// clientset is used for the pod logs, the controller runtime client can not stream them
+	clientset kubernetes.Interface
 }
 
 func NewKubeClient(logger logr.Logger) *KubeClient {
@@ -38,8 +42,9 @@ func NewKubeClient(logger logr.Logger) *KubeClient {
 	}
 
 	return &KubeClient{
-		log:     logger,
-		kClient: controllerClient,
+		log:       logger,
+		kClient:   controllerClient,
+		clientset: kubernetes.NewForConfigOrDie(kubeconfig),
 	}
 }
 
@@ -0,0 +1,70 @@
+package kubeclient
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	kbatch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxLogBytes comments of the git providers are limited in size
+const maxLogBytes = 32 * 1024
+
+// InstanceLogTail returns the last lines of the logs of the instance
+// the logs of the application while it is deployed or running, otherwise the logs of the last build
+// empty if there is no pod to read the logs from
+func (k *KubeClient) InstanceLogTail(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, lines int64) (string, error) {
+	switch pei.Status.Phase {
+	case coflnetv1alpha1.InstancePhaseDeploying, coflnetv1alpha1.InstancePhaseRunning:
+		return PodLogTail(ctx, k.kClient, k.clientset, pei.GetNamespace(), client.MatchingLabels{"app": pei.GetName()}, pei.GetName(), lines)
+	}
+	return BuildLogTail(ctx, k.kClient, k.clientset, pei, lines)
+}
+
+// BuildLogTail returns the last lines of the logs of the last build of the instance
+// empty if the instance was not built yet or the pod of the build job is gone
+func BuildLogTail(ctx context.Context, c client.Client, clientset kubernetes.Interface, pei *coflnetv1alpha1.PreviewEnvironmentInstance, lines int64) (string, error) {
+	if pei.Status.Build == nil || pei.Status.Build.JobName == "" {
+		return "", nil
+	}
+	return PodLogTail(ctx, c, clientset, pei.GetNamespace(), client.MatchingLabels{kbatch.JobNameLabel: pei.Status.Build.JobName}, "kaniko", lines)
+}
+
+// PodLogTail reads the logs of the container of the newest pod with the given labels
+// the newest pod has the logs of the last attempt, the logs are limited to the size git providers accept
+func PodLogTail(ctx context.Context, c client.Client, clientset kubernetes.Interface, namespace string, selector client.MatchingLabels, container string, lines int64) (string, error) {
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace(namespace), selector); err != nil {
+		return "", err
+	}
+	if len(pods.Items) == 0 {
+		return "", nil
+	}
+
+	latest := slices.MaxFunc(pods.Items, func(a, b corev1.Pod) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+
+	limitBytes := int64(maxLogBytes)
+	stream, err := clientset.CoreV1().Pods(namespace).GetLogs(latest.Name, &corev1.PodLogOptions{
+		Container:  container,
+		TailLines:  &lines,
+		LimitBytes: &limitBytes,
+	}).Stream(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	logs, err := io.ReadAll(stream)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(logs)), nil
+}
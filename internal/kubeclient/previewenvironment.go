@@ -2,6 +2,7 @@ package kubeclient
 
 import (
 	"context"
+	"strings"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -126,3 +127,19 @@ func (k *KubeClient) DeletePreviewEnvironment(ctx context.Context, owner string,
 	}
 	return pe, nil
 }
+
+// PreviewEnvironmentsByOrganizationAndRepository lists the preview environments of all users for a repository
+func (k *KubeClient) PreviewEnvironmentsByOrganizationAndRepository(ctx context.Context, organization, repo string) ([]coflnetv1alpha1.PreviewEnvironment, error) {
+	var peList coflnetv1alpha1.PreviewEnvironmentList
+	if err := k.kClient.List(ctx, &peList, &client.ListOptions{Namespace: namespace()}); err != nil {
+		return nil, err
+	}
+
+	result := []coflnetv1alpha1.PreviewEnvironment{}
+	for _, pe := range peList.Items {
+		if strings.EqualFold(pe.Spec.GitSettings.Organization, organization) && strings.EqualFold(pe.Spec.GitSettings.Repository, repo) {
+			result = append(result, pe)
+		}
+	}
+	return result, nil
+}
@@ -2,9 +2,13 @@ package kubeclient
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -34,7 +38,7 @@ func (k *KubeClient) PreviewEnvironmentByOrganizationRepoAndIdentifier(ctx conte
 	labelSelector := labels.Set(map[string]string{
 		"github-organization": organization,
 		"github-repository":   repo,
-		"github-identifier":   identifier,
+		"github-identifier":   coflnetv1alpha1.SafeIdentifier(identifier),
 	}).AsSelector()
 	k.log.Info("Getting PreviewEnvironmentInstance from the cluster", "organization", organization, "repository", repo, "identifier", identifier)
 
@@ -48,33 +52,224 @@ func (k *KubeClient) PreviewEnvironmentByOrganizationRepoAndIdentifier(ctx conte
 		return nil, err
 	}
 
-	if len(peiList.Items) == 0 {
+	matching := instancesWithIdentifier(peiList.Items, identifier)
+	if len(matching) == 0 {
 		return nil, errors.NewNotFound(coflnetv1alpha1.PreviewEnvironmentInstanceGVR.GroupResource(), labelSelector.String())
 	}
 
-	return &peiList.Items[0], nil
+	return &matching[0], nil
 }
 
-func (k *KubeClient) TriggerUpdateForPreviewEnvironmentInstance(ctx context.Context, owner string, peId types.UID, branchOrPullRequestIdentifier string) error {
-	peiList, err := k.ListPreviewEnvironmentInstancesByPreviewEnvironmentId(ctx, owner, peId)
+// PreviewEnvironmentInstancesByOrganizationRepoAndIdentifier lists the instances of all users for a pull request or branch
+func (k *KubeClient) PreviewEnvironmentInstancesByOrganizationRepoAndIdentifier(ctx context.Context, organization, repo, identifier string) ([]coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	var peiList coflnetv1alpha1.PreviewEnvironmentInstanceList
+	err := k.kClient.List(ctx, &peiList, &client.ListOptions{
+		Namespace: namespace(),
+		LabelSelector: labels.Set(map[string]string{
+			"github-organization": organization,
+			"github-repository":   repo,
+			"github-identifier":   coflnetv1alpha1.SafeIdentifier(identifier),
+		}).AsSelector(),
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, pei := range peiList.Items {
-		if pei.BranchOrPullRequestIdentifier() == branchOrPullRequestIdentifier {
-			k.log.Info("Found matching PreviewEnvironmentInstance", "name", pei.GetName(), "namespace", pei.GetNamespace())
+	return instancesWithIdentifier(peiList.Items, identifier), nil
+}
 
-			// update the status field
-			pei.Status.Phase = coflnetv1alpha1.InstancePhasePending
-			err := k.kClient.Status().Update(ctx, &pei)
-			if err != nil {
-				return err
-			}
+// PreviewEnvironmentInstancesByOrganizationRepoAndBranch lists the instances that build the given branch of the repository
+// these are the instances of the branch itself and of the pull requests with the branch as head, pull requests of forks build a branch of another repository
+func (k *KubeClient) PreviewEnvironmentInstancesByOrganizationRepoAndBranch(ctx context.Context, organization, repo, branch string) ([]coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	var peiList coflnetv1alpha1.PreviewEnvironmentInstanceList
+	err := k.kClient.List(ctx, &peiList, &client.ListOptions{
+		Namespace: namespace(),
+		LabelSelector: labels.Set(map[string]string{
+			"github-organization": organization,
+			"github-repository":   repo,
+		}).AsSelector(),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-			k.log.Info("Updated PreviewEnvironmentInstance", "name", pei.GetName(), "phase", coflnetv1alpha1.InstancePhasePending, "namespace", pei.GetNamespace())
+	result := []coflnetv1alpha1.PreviewEnvironmentInstance{}
+	for _, pei := range peiList.Items {
+		gitSettings := pei.Spec.InstanceGitSettings
+		if gitSettings.Fork || gitSettings.Branch == nil || *gitSettings.Branch != branch {
+			continue
 		}
+		result = append(result, pei)
 	}
+	return result, nil
+}
 
+// instancesWithIdentifier filters out instances whose branch only has the same label value as the identifier
+func instancesWithIdentifier(peis []coflnetv1alpha1.PreviewEnvironmentInstance, identifier string) []coflnetv1alpha1.PreviewEnvironmentInstance {
+	result := []coflnetv1alpha1.PreviewEnvironmentInstance{}
+	for _, pei := range peis {
+		if pei.BranchOrPullRequestIdentifier() == identifier {
+			result = append(result, pei)
+		}
+	}
+	return result
+}
+
+// SetDesiredPhaseOfPreviewEnvironmentInstance sets the desired phase of an instance of the given preview environment
+// the controller takes care of stopping or starting the instance
+func (k *KubeClient) SetDesiredPhaseOfPreviewEnvironmentInstance(ctx context.Context, owner string, peId types.UID, name, desiredPhase string) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	pei, err := k.previewEnvironmentInstanceByName(ctx, owner, peId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pei, k.SetDesiredPhase(ctx, pei, desiredPhase)
+}
+
+// SetDesiredPhase sets the desired phase of the instance, the controller takes care of stopping or starting it
+// starting an expired instance extends it, otherwise the expiry policy would stop it again right away
+func (k *KubeClient) SetDesiredPhase(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, desiredPhase string) error {
+	pei.Spec.DesiredPhase = desiredPhase
+	if desiredPhase == coflnetv1alpha1.InstancePhaseRunning && pei.Status.ExpiresAt != nil && !pei.Status.ExpiresAt.After(time.Now()) {
+		extendedUntil := metav1.NewTime(time.Now().Add(coflnetv1alpha1.DefaultExtension))
+		pei.Spec.ExtendedUntil = &extendedUntil
+	}
+	if err := k.kClient.Update(ctx, pei); err != nil {
+		return err
+	}
+
+	k.log.Info("Updated desired phase of PreviewEnvironmentInstance", "name", pei.GetName(), "desiredPhase", desiredPhase, "namespace", pei.GetNamespace())
 	return nil
 }
+
+// ExtendPreviewEnvironmentInstance moves the expiry of an instance of the given preview environment back by the given duration
+func (k *KubeClient) ExtendPreviewEnvironmentInstance(ctx context.Context, owner string, peId types.UID, name string, duration time.Duration) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	pei, err := k.previewEnvironmentInstanceByName(ctx, owner, peId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return pei, k.ExtendInstance(ctx, pei, duration)
+}
+
+// ExtendInstance moves the expiry of the instance back by the given duration
+// an instance that was already stopped is started again
+func (k *KubeClient) ExtendInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, duration time.Duration) error {
+	base := time.Now()
+	if pei.Status.ExpiresAt != nil && pei.Status.ExpiresAt.After(base) {
+		base = pei.Status.ExpiresAt.Time
+	}
+
+	extendedUntil := metav1.NewTime(base.Add(duration))
+	pei.Spec.ExtendedUntil = &extendedUntil
+	if pei.Spec.DesiredPhase == coflnetv1alpha1.InstancePhaseStopped {
+		pei.Spec.DesiredPhase = coflnetv1alpha1.InstancePhaseRunning
+	}
+
+	k.log.Info("Extending PreviewEnvironmentInstance", "name", pei.GetName(), "namespace", pei.GetNamespace(), "extendedUntil", extendedUntil)
+	return k.kClient.Update(ctx, pei)
+}
+
+// ApprovePreviewEnvironmentInstance approves the reviewed commit of a fork instance of the given preview environment
+// returns a CommitNotReviewedError if the instance is at another commit
+func (k *KubeClient) ApprovePreviewEnvironmentInstance(ctx context.Context, owner string, peId types.UID, name, reviewed string) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	pei, err := k.previewEnvironmentInstanceByName(ctx, owner, peId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// the fork might have pushed after the review, that commit has to be reviewed first
+	if !pei.IsReviewedCommit(reviewed) {
+		return pei, CommitNotReviewedError{Instance: pei.GetName(), Commit: pei.Spec.InstanceGitSettings.CommitHash, Reviewed: reviewed}
+	}
+
+	return pei, k.ApproveInstance(ctx, pei, pei.Spec.InstanceGitSettings.CommitHash)
+}
+
+// CommitNotReviewedError is returned if an instance is at another commit than the one that was reviewed
+type CommitNotReviewedError struct {
+	Instance string
+	Commit   string
+	Reviewed string
+}
+
+func (e CommitNotReviewedError) Error() string {
+	return fmt.Sprintf("instance %s is at commit %s, not at the reviewed commit %s", e.Instance, e.Commit, e.Reviewed)
+}
+
+// ApproveInstance approves the given commit of the instance to be built, only necessary for forks
+// the commit is built once the instance reaches it, any other commit has to be approved again
+func (k *KubeClient) ApproveInstance(ctx context.Context, pei *coflnetv1alpha1.PreviewEnvironmentInstance, sha string) error {
+	if sha == "" || pei.Spec.ApprovedCommitHash == sha {
+		return nil
+	}
+
+	pei.Spec.ApprovedCommitHash = sha
+	k.log.Info("Approving PreviewEnvironmentInstance", "name", pei.GetName(), "namespace", pei.GetNamespace(), "commit", pei.Spec.ApprovedCommitHash)
+	return k.kClient.Update(ctx, pei)
+}
+
+func (k *KubeClient) previewEnvironmentInstanceByName(ctx context.Context, owner string, peId types.UID, name string) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	peiList, err := k.ListPreviewEnvironmentInstancesByPreviewEnvironmentId(ctx, owner, peId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pei := range peiList.Items {
+		if pei.GetName() == name {
+			return &pei, nil
+		}
+	}
+
+	return nil, errors.NewNotFound(coflnetv1alpha1.PreviewEnvironmentInstanceGVR.GroupResource(), name)
+}
+
+// RequestRebuildForPreviewEnvironmentInstance marks the instance so the controller builds and deploys its commit again
+// the instance has to belong to the given repository, the request comes from a webhook of that repository
+func (k *KubeClient) RequestRebuildForPreviewEnvironmentInstance(ctx context.Context, organization, repo, name string) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	var pei coflnetv1alpha1.PreviewEnvironmentInstance
+	if err := k.kClient.Get(ctx, client.ObjectKey{Namespace: namespace(), Name: name}, &pei); err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(pei.GetLabels()["github-organization"], organization) || !strings.EqualFold(pei.GetLabels()["github-repository"], repo) {
+		return nil, errors.NewNotFound(coflnetv1alpha1.PreviewEnvironmentInstanceGVR.GroupResource(), name)
+	}
+
+	annotations := pei.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[coflnetv1alpha1.RebuildRequestedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	pei.SetAnnotations(annotations)
+
+	k.log.Info("Requesting rebuild of PreviewEnvironmentInstance", "name", pei.GetName(), "namespace", pei.GetNamespace())
+	return &pei, k.kClient.Update(ctx, &pei)
+}
+
+// RequestWakeForPreviewEnvironmentInstance marks an idle instance so the controller scales it up again
+// returns the current phase of the instance
+func (k *KubeClient) RequestWakeForPreviewEnvironmentInstance(ctx context.Context, name string) (string, error) {
+	var pei coflnetv1alpha1.PreviewEnvironmentInstance
+	if err := k.kClient.Get(ctx, client.ObjectKey{Namespace: namespace(), Name: name}, &pei); err != nil {
+		return "", err
+	}
+
+	if pei.Status.Phase != coflnetv1alpha1.InstancePhaseIdle {
+		return pei.Status.Phase, nil
+	}
+
+	if _, requested := pei.GetAnnotations()[coflnetv1alpha1.WakeRequestedAnnotation]; requested {
+		return pei.Status.Phase, nil
+	}
+
+	annotations := pei.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[coflnetv1alpha1.WakeRequestedAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	pei.SetAnnotations(annotations)
+
+	k.log.Info("Requesting wake up of PreviewEnvironmentInstance", "name", pei.GetName(), "namespace", pei.GetNamespace())
+	return pei.Status.Phase, k.kClient.Update(ctx, &pei)
+}
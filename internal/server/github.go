@@ -2,6 +2,9 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
 
@@ -10,7 +13,7 @@ import (
 	apigen "github.com/coflnet/pr-env/internal/server/openapi"
 	"github.com/google/go-github/v66/github"
 	"github.com/labstack/echo/v4"
-	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/api/errors"
 )
 
 func (s Server) convertToGithubRepositoryModelList(repos []*github.Repository) []apigen.GithubRepositoryModel {
@@ -118,73 +121,276 @@ func (s Server) ConfigureInstallation(c echo.Context) error {
 	return c.JSON(200, "ok")
 }
 
+// GithubWebhookHandler receives the webhook deliveries of the github app
+// deliveries without a valid signature are rejected
 func (s *Server) GithubWebhookHandler(c echo.Context) error {
 	s.log.Info("Received Github Webhook")
 
-	payload, err := github.ValidatePayload(c.Request(), []byte(""))
+	secret := githubWebhookSecret()
+	if secret == "" {
+		s.log.Error(fmt.Errorf("GITHUB_WEBHOOK_SECRET is not set"), "Rejecting webhook, no secret configured")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "webhook secret is not configured")
+	}
+
+	payload, err := github.ValidatePayload(c.Request(), []byte(secret))
 	if err != nil {
 		s.log.Error(err, "Unable to validate payload")
-		return err
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid signature")
 	}
 
 	t := github.WebHookType(c.Request())
 	event, err := github.ParseWebHook(t, payload)
 	if err != nil {
 		s.log.Error(err, "Unable to parse")
-		return err
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	ctx := c.Request().Context()
 	switch event := event.(type) {
 	case *github.PushEvent:
-		err := s.HandleGithubPush(c.Request().Context(), event)
-		if err != nil {
-			s.log.Error(err, "Unable to handle push event")
-		}
-		return err
+		err = s.HandleGithubPush(ctx, event)
 	case *github.PullRequestEvent:
-		err := s.HandleGithubPullRequest(c.Request().Context(), event)
-		if err != nil {
-			s.log.Error(err, "Unable to handle pull request event")
+		err = s.HandleGithubPullRequest(ctx, event)
+	case *github.IssueCommentEvent:
+		err = s.HandleGithubIssueComment(ctx, event)
+	case *github.CheckRunEvent:
+		err = s.HandleGithubCheckRun(ctx, event)
+	case *github.InstallationEvent:
+		err = s.HandleGithubInstallation(ctx, event)
+	case *github.InstallationRepositoriesEvent:
+		err = s.syncRepositories(ctx, event.RepositoriesAdded)
+	default:
+		s.log.Info("Received event but not an important one", "type", t)
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	if err != nil {
+		s.log.Error(err, "Unable to handle event", "type", t)
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) HandleGithubPush(ctx context.Context, event *github.PushEvent) error {
+	// tags are not built
+	ref := event.GetRef()
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return nil
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	owner, repo := repositoryOwner(event.GetRepo().GetOwner()), event.GetRepo().GetName()
+
+	// new and deleted branches change the list of instances
+	if event.GetCreated() || event.GetDeleted() {
+		if err := s.syncPreviewEnvironments(ctx, owner, repo); err != nil {
+			return err
 		}
+	}
+
+	// the instances store the branch they build, no need to ask github for the pull requests of the branch
+	peis, err := s.kubeClient.PreviewEnvironmentInstancesByOrganizationRepoAndBranch(ctx, owner, repo, branch)
+	if err != nil {
 		return err
+	}
+
+	s.log.Info("Handling push", "owner", owner, "repo", repo, "branch", branch, "instances", len(peis))
+	for _, pei := range peis {
+		s.events.EnqueueInstance(&pei)
+	}
+	return nil
+}
+
+func (s *Server) HandleGithubPullRequest(ctx context.Context, event *github.PullRequestEvent) error {
+	owner, repo := repositoryOwner(event.GetRepo().GetOwner()), event.GetRepo().GetName()
+
+	switch event.GetAction() {
+	case "labeled":
+		if err := s.approveForkWithLabel(ctx, event); err != nil {
+			return err
+		}
+		return s.syncPreviewEnvironments(ctx, owner, repo)
+	case "opened", "reopened", "closed", "unlabeled", "ready_for_review", "converted_to_draft", "edited":
+		// the preview environment creates or cleans up the instance of the pull request
+		// labels, the draft state and the base branch decide if the pull request gets an instance
+		return s.syncPreviewEnvironments(ctx, owner, repo)
+	case "synchronize":
+		return s.HandleGithubEvent(ctx, owner, repo, strconv.Itoa(event.GetPullRequest().GetNumber()))
 	default:
-		s.log.Info("Received event but not an important one", "type", t)
+		s.log.Info("Ignoring pull request action", "action", event.GetAction(), "owner", owner, "repo", repo)
 		return nil
 	}
 }
 
-func (s *Server) HandleGithubPush(ctx context.Context, event *github.PushEvent) error {
-	ref := *event.Ref
-	branch := strings.Split(ref, "/")[2]
+// approveForkWithLabel approves the head commit of a fork pull request when a maintainer adds the approval label
+// only the commit at that moment is approved, the label stays on the pull request but does not approve later pushes
+func (s *Server) approveForkWithLabel(ctx context.Context, event *github.PullRequestEvent) error {
+	pr := event.GetPullRequest()
+	if pr.GetHead().GetRepo().GetID() == pr.GetBase().GetRepo().GetID() {
+		return nil
+	}
 
-	owner, repo := *event.Repo.Owner.Name, *event.Repo.Name
-	prs, err := s.githubClient.PullRequestsOfRepositoryAndBranch(ctx, owner, repo, branch)
+	owner, repo := repositoryOwner(event.GetRepo().GetOwner()), event.GetRepo().GetName()
+	pes, err := s.kubeClient.PreviewEnvironmentsByOrganizationAndRepository(ctx, owner, repo)
 	if err != nil {
 		return err
 	}
 
-	s.log.Info("Searched pull requests for branch", "owner", owner, "repo", repo, "branch", branch, "prs", len(prs))
+	approving := map[string]bool{}
+	for _, pe := range pes {
+		if pe.Spec.BuildSettings.HasForkApprovalLabel([]string{event.GetLabel().GetName()}) {
+			approving[string(pe.GetUID())] = true
+		}
+	}
+	if len(approving) == 0 {
+		return nil
+	}
 
-	for _, pr := range prs {
-		err = s.HandleGithubEvent(ctx, owner, repo, *pr.Number)
-		if err != nil {
+	// labels can also be added by people that are not allowed to run code in the cluster
+	sender := event.GetSender().GetLogin()
+	writeAccess, err := s.githubClientForInstallation(ctx, event.GetInstallation()).HasWriteAccess(ctx, owner, repo, sender)
+	if err != nil {
+		return err
+	}
+	if !writeAccess {
+		s.log.Info("Ignoring approval label of a user without write access", "owner", owner, "repo", repo, "prNr", pr.GetNumber(), "user", sender)
+		return nil
+	}
+
+	peis, err := s.kubeClient.PreviewEnvironmentInstancesByOrganizationRepoAndIdentifier(ctx, owner, repo, strconv.Itoa(pr.GetNumber()))
+	if err != nil {
+		return err
+	}
+
+	for i := range peis {
+		pei := &peis[i]
+		if !pei.Spec.InstanceGitSettings.Fork || !approving[pei.GetPreviewEnvironmentId()] {
+			continue
+		}
+
+		if err := s.kubeClient.ApproveInstance(ctx, pei, pr.GetHead().GetSHA()); err != nil {
 			return err
 		}
+		s.events.EnqueueInstance(pei)
+	}
+	return nil
+}
+
+// HandleGithubCheckRun rebuilds the instance when the rebuild button or re-run of its check run is used
+func (s *Server) HandleGithubCheckRun(ctx context.Context, event *github.CheckRunEvent) error {
+	switch event.GetAction() {
+	case "rerequested":
+	case "requested_action":
+		if event.GetRequestedAction().Identifier != git.RebuildAction {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	// only check runs of the operator carry the name of the instance
+	name := event.GetCheckRun().GetExternalID()
+	if name == "" {
+		return nil
+	}
+
+	owner, repo := repositoryOwner(event.GetRepo().GetOwner()), event.GetRepo().GetName()
+	pei, err := s.kubeClient.RequestRebuildForPreviewEnvironmentInstance(ctx, owner, repo, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			s.log.Info("Check run does not belong to an instance", "owner", owner, "repo", repo, "name", name)
+			return nil
+		}
+		return err
 	}
 
+	s.events.EnqueueInstance(pei)
 	return nil
 }
 
-func (s *Server) HandleGithubPullRequest(ctx context.Context, event *github.PullRequestEvent) error {
-	return s.HandleGithubEvent(ctx, *event.Repo.Owner.Name, *event.Repo.Name, *event.PullRequest.Number)
+func (s *Server) HandleGithubInstallation(ctx context.Context, event *github.InstallationEvent) error {
+	switch event.GetAction() {
+	case "created":
+	case "deleted", "suspend":
+		// the cached token of the installation does not work anymore
+		s.githubClient.ForgetInstallation(int(event.GetInstallation().GetID()))
+		return nil
+	default:
+		s.log.Info("Ignoring installation action", "action", event.GetAction(), "id", event.GetInstallation().GetID())
+		return nil
+	}
+
+	if err := s.githubClient.ConfigureInstallation(ctx, event.GetInstallation()); err != nil {
+		return err
+	}
+
+	return s.syncRepositories(ctx, event.Repositories)
 }
 
-func (s *Server) HandleGithubEvent(ctx context.Context, owner, repo string, prNumber int) error {
-	pei, err := s.kubeClient.PreviewEnvironmentByOrganizationRepoAndIdentifier(ctx, owner, repo, strconv.Itoa(prNumber))
+// HandleGithubEvent reconciles the instances of a pull request or branch right away
+func (s *Server) HandleGithubEvent(ctx context.Context, owner, repo, identifier string) error {
+	peis, err := s.kubeClient.PreviewEnvironmentInstancesByOrganizationRepoAndIdentifier(ctx, owner, repo, identifier)
 	if err != nil {
 		return err
 	}
 
-	s.log.Info("Handling Github event", "owner", owner, "repo", repo)
-	return s.kubeClient.TriggerUpdateForPreviewEnvironmentInstance(ctx, pei.GetOwner(), types.UID(pei.GetPreviewEnvironmentId()), pei.BranchOrPullRequestIdentifier())
+	s.log.Info("Handling Github event", "owner", owner, "repo", repo, "identifier", identifier, "instances", len(peis))
+	for _, pei := range peis {
+		s.events.EnqueueInstance(&pei)
+	}
+	return nil
+}
+
+// syncPreviewEnvironments reconciles all preview environments of the repository right away
+func (s *Server) syncPreviewEnvironments(ctx context.Context, owner, repo string) error {
+	pes, err := s.kubeClient.PreviewEnvironmentsByOrganizationAndRepository(ctx, owner, repo)
+	if err != nil {
+		return err
+	}
+
+	for _, pe := range pes {
+		s.events.EnqueuePreviewEnvironment(&pe)
+	}
+	return nil
+}
+
+func (s *Server) syncRepositories(ctx context.Context, repos []*github.Repository) error {
+	for _, r := range repos {
+		// installation events only contain the full name of the repositories
+		owner, repo, ok := strings.Cut(r.GetFullName(), "/")
+		if !ok {
+			continue
+		}
+
+		if err := s.syncPreviewEnvironments(ctx, owner, repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repositoryOwner push events contain the name of the owner, all other events only the login
+func repositoryOwner(owner *github.User) string {
+	if owner.GetLogin() != "" {
+		return owner.GetLogin()
+	}
+	return owner.GetName()
+}
+
+func githubWebhookSecret() string {
+	return os.Getenv("GITHUB_WEBHOOK_SECRET")
+}
+
+// githubClientForInstallation returns a client that acts as the installation that sent the webhook
+// falls back to the default client if the event has no installation
+func (s *Server) githubClientForInstallation(ctx context.Context, installation *github.Installation) *git.GithubClient {
+	if installation.GetID() == 0 {
+		return s.githubClient
+	}
+
+	gc, err := s.githubClient.ForInstallation(ctx, int(installation.GetID()))
+	if err != nil {
+		s.log.Error(err, "Unable to create a client for the installation", "id", installation.GetID())
+		return s.githubClient
+	}
+	return gc
 }
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
+	"github.com/google/go-github/v66/github"
+)
+
+// previewCommandPrefix comments starting with this prefix are commands for the operator
+const previewCommandPrefix = "/preview"
+
+// commandLogLines how many lines of logs the logs command replies with
+const commandLogLines = 50
+
+// writeAccessCommands the commands only people with write access to the repository can use, with what they allow
+var writeAccessCommands = map[string]string{
+	"approve": "approve builds",
+	"logs":    "read the logs",
+}
+
+// previewCommandUsage is the reply to unknown commands
+const previewCommandUsage = "Available commands: `/preview rebuild`, `/preview stop`, `/preview start`, `/preview extend [duration]`, `/preview logs` and `/preview approve <commit>`."
+
+// HandleGithubIssueComment executes the preview commands of pull request comments
+// the commands apply to all instances of the pull request the author is allowed to control
+func (s *Server) HandleGithubIssueComment(ctx context.Context, event *github.IssueCommentEvent) error {
+	if event.GetAction() != "created" || !event.GetIssue().IsPullRequest() {
+		return nil
+	}
+
+	command, args, ok := parsePreviewCommand(event.GetComment().GetBody())
+	if !ok {
+		return nil
+	}
+
+	owner, repo, prNumber := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName(), event.GetIssue().GetNumber()
+	user, commentId := event.GetComment().GetUser().GetLogin(), event.GetComment().GetID()
+	s.log.Info("Received preview command", "owner", owner, "repo", repo, "prNr", prNumber, "command", command, "user", user)
+	gc := s.githubClientForInstallation(ctx, event.GetInstallation())
+
+	peis, writeAccess, err := s.commandInstances(ctx, gc, owner, repo, prNumber, user)
+	if err != nil {
+		return err
+	}
+	if len(peis) == 0 {
+		s.react(ctx, gc, owner, repo, commentId, "-1")
+		return gc.ReplyToPullRequest(ctx, owner, repo, prNumber, fmt.Sprintf("@%s there is no preview environment of this pull request you are allowed to control.", user))
+	}
+
+	// running the code of a fork is only up to maintainers of the repository
+	// the logs may contain secrets of the environment, they are not posted for everyone who can comment
+	if action, restricted := writeAccessCommands[command]; restricted && !writeAccess {
+		s.react(ctx, gc, owner, repo, commentId, "-1")
+		return gc.ReplyToPullRequest(ctx, owner, repo, prNumber, fmt.Sprintf("@%s only people with write access to the repository can %s.", user, action))
+	}
+
+	message, err := s.runPreviewCommand(ctx, owner, repo, command, args, peis)
+	if err != nil {
+		s.react(ctx, gc, owner, repo, commentId, "confused")
+		return err
+	}
+	if message == "" {
+		s.react(ctx, gc, owner, repo, commentId, "confused")
+		return gc.ReplyToPullRequest(ctx, owner, repo, prNumber, fmt.Sprintf("Unknown preview command `%s`. %s", command, previewCommandUsage))
+	}
+
+	s.react(ctx, gc, owner, repo, commentId, "+1")
+	return gc.ReplyToPullRequest(ctx, owner, repo, prNumber, message)
+}
+
+// commandInstances returns the instances of the pull request the user is allowed to control and if the user has write access
+// people with write access to the repository control all of them, the users of the access settings the ones of their preview environment
+func (s *Server) commandInstances(ctx context.Context, gc *git.GithubClient, owner, repo string, prNumber int, user string) ([]coflnetv1alpha1.PreviewEnvironmentInstance, bool, error) {
+	peis, err := s.kubeClient.PreviewEnvironmentInstancesByOrganizationRepoAndIdentifier(ctx, owner, repo, strconv.Itoa(prNumber))
+	if err != nil || len(peis) == 0 {
+		return nil, false, err
+	}
+
+	writeAccess, err := gc.HasWriteAccess(ctx, owner, repo, user)
+	if err != nil {
+		s.log.Error(err, "Unable to check the permission of the user", "owner", owner, "repo", repo, "user", user)
+	}
+	if writeAccess {
+		return peis, true, nil
+	}
+
+	pes, err := s.kubeClient.PreviewEnvironmentsByOrganizationAndRepository(ctx, owner, repo)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var result []coflnetv1alpha1.PreviewEnvironmentInstance
+	for _, pei := range peis {
+		for _, pe := range pes {
+			if string(pe.GetUID()) == pei.GetPreviewEnvironmentId() && pe.HasAccess(user) {
+				result = append(result, pei)
+				break
+			}
+		}
+	}
+	return result, false, nil
+}
+
+// runPreviewCommand applies the command to the instances and returns the reply, empty if the command is unknown
+func (s *Server) runPreviewCommand(ctx context.Context, owner, repo, command string, args []string, peis []coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+	var action func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error)
+
+	switch command {
+	case "rebuild":
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			rebuilt, err := s.kubeClient.RequestRebuildForPreviewEnvironmentInstance(ctx, owner, repo, pei.GetName())
+			if err != nil {
+				return "", err
+			}
+			s.events.EnqueueInstance(rebuilt)
+			return fmt.Sprintf("`%s` is being rebuilt.", pei.GetName()), nil
+		}
+	case "stop":
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			if err := s.kubeClient.SetDesiredPhase(ctx, pei, coflnetv1alpha1.InstancePhaseStopped); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("`%s` is being stopped.", pei.GetName()), nil
+		}
+	case "start":
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			// idle instances still want to run, they only have to be woken up
+			if pei.Status.Phase == coflnetv1alpha1.InstancePhaseIdle {
+				if _, err := s.kubeClient.RequestWakeForPreviewEnvironmentInstance(ctx, pei.GetName()); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("`%s` is being woken up.", pei.GetName()), nil
+			}
+
+			if err := s.kubeClient.SetDesiredPhase(ctx, pei, coflnetv1alpha1.InstancePhaseRunning); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("`%s` is being started.", pei.GetName()), nil
+		}
+	case "extend":
+		duration := coflnetv1alpha1.DefaultExtension
+		if len(args) > 0 {
+			d, err := parseExtension(args[0])
+			if err != nil {
+				return fmt.Sprintf("`%s` is not a valid duration, use something like `48h` or `3d`.", args[0]), nil
+			}
+			duration = d
+		}
+
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			if err := s.kubeClient.ExtendInstance(ctx, pei, duration); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("`%s` was extended until %s.", pei.GetName(), pei.Spec.ExtendedUntil.UTC().Format(time.RFC1123)), nil
+		}
+	case "approve":
+		// the commit the maintainer reviewed is required, a push right before the command must not get approved with it
+		if len(args) == 0 || len(args[0]) < coflnetv1alpha1.MinReviewedCommitLength {
+			return fmt.Sprintf("Add the commit you reviewed, e.g. `/preview approve <commit>`, with at least %d characters of it.", coflnetv1alpha1.MinReviewedCommitLength), nil
+		}
+		reviewed := args[0]
+
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			commit := pei.Spec.InstanceGitSettings.CommitHash
+			if !pei.Spec.InstanceGitSettings.Fork {
+				return fmt.Sprintf("`%s` does not need an approval, the pull request does not come from a fork.", pei.GetName()), nil
+			}
+			if commit == "" {
+				return fmt.Sprintf("`%s` has no commit to approve yet.", pei.GetName()), nil
+			}
+			// someone pushed after the review, the new commit has to be reviewed first
+			if !pei.IsReviewedCommit(reviewed) {
+				return fmt.Sprintf("`%s` is at commit %s, not %s. Review the new commit and approve it again.", pei.GetName(), commit, reviewed), nil
+			}
+
+			if err := s.kubeClient.ApproveInstance(ctx, pei, commit); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Commit %s of `%s` was approved and will be built.", pei.Spec.ApprovedCommitHash, pei.GetName()), nil
+		}
+	case "logs":
+		action = func(pei *coflnetv1alpha1.PreviewEnvironmentInstance) (string, error) {
+			logs, err := s.kubeClient.InstanceLogTail(ctx, pei, commandLogLines)
+			if err != nil {
+				return "", err
+			}
+			if logs == "" {
+				return fmt.Sprintf("`%s` has no logs yet.", pei.GetName()), nil
+			}
+			return fmt.Sprintf("<details><summary>Logs of <code>%s</code> (%s)</summary>\n\n%s\n</details>", pei.GetName(), pei.Status.Phase, git.CodeBlock(logs)), nil
+		}
+	default:
+		return "", nil
+	}
+
+	replies := make([]string, 0, len(peis))
+	for i := range peis {
+		reply, err := action(&peis[i])
+		if err != nil {
+			return "", err
+		}
+		replies = append(replies, reply)
+	}
+	return strings.Join(replies, "\n\n"), nil
+}
+
+// react adds a reaction to the command, failing to react does not fail the command
+func (s *Server) react(ctx context.Context, gc *git.GithubClient, owner, repo string, commentId int64, reaction string) {
+	if err := gc.ReactToComment(ctx, owner, repo, commentId, reaction); err != nil {
+		s.log.Error(err, "Unable to react to the comment", "owner", owner, "repo", repo, "commentId", commentId)
+	}
+}
+
+// parsePreviewCommand returns the command and its arguments if the comment starts with the preview prefix
+func parsePreviewCommand(body string) (string, []string, bool) {
+	fields := strings.Fields(strings.TrimSpace(body))
+	if len(fields) < 2 || fields[0] != previewCommandPrefix {
+		return "", nil, false
+	}
+	return strings.ToLower(fields[1]), fields[2:], true
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePreviewCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		command string
+		args    []string
+		ok      bool
+	}{
+		{name: "no command", body: "/preview"},
+		{name: "prefix with whitespace only", body: "/preview   \n"},
+		{name: "normal comment", body: "looks good to me"},
+		{name: "prefix not at the start", body: "please /preview rebuild"},
+		{name: "prefix without separator", body: "/previewrebuild"},
+		{name: "empty comment", body: ""},
+		{name: "command", body: "/preview rebuild", command: "rebuild", args: []string{}, ok: true},
+		{name: "command is case insensitive", body: "/preview Rebuild", command: "rebuild", args: []string{}, ok: true},
+		{name: "surrounding whitespace", body: "\n  /preview stop  \n", command: "stop", args: []string{}, ok: true},
+		{name: "argument", body: "/preview extend 3d", command: "extend", args: []string{"3d"}, ok: true},
+		{name: "arguments keep their case", body: "/preview approve ABC1234", command: "approve", args: []string{"ABC1234"}, ok: true},
+		{name: "text on the next lines", body: "/preview logs\nthe build fails", command: "logs", args: []string{"the", "build", "fails"}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args, ok := parsePreviewCommand(tt.body)
+			if ok != tt.ok || command != tt.command || !slices.Equal(args, tt.args) {
+				t.Errorf("parsePreviewCommand(%q) = %q, %q, %v, expected %q, %q, %v", tt.body, command, args, ok, tt.command, tt.args, tt.ok)
+			}
+		})
+	}
+}
@@ -8,12 +8,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/oapi-codegen/runtime"
 	strictecho "github.com/oapi-codegen/runtime/strictmiddleware/echo"
 )
 
+// Defines values for GitSettingsModelProvider.
+const (
+	Gitea  GitSettingsModelProvider = "gitea"
+	Github GitSettingsModelProvider = "github"
+	Gitlab GitSettingsModelProvider = "gitlab"
+)
+
 // AccessSettingsModel defines model for accessSettingsModel.
 type AccessSettingsModel struct {
 	Users []struct {
@@ -29,12 +37,40 @@ type ApplicationSettingsModel struct {
 	Port                 int                         `json:"port"`
 }
 
+// ApproveInstanceModel defines model for approveInstanceModel.
+type ApproveInstanceModel struct {
+	// CommitHash the reviewed commit, at least 7 characters, the approval is rejected if the instance is at another commit
+	CommitHash string `json:"commitHash"`
+}
+
 // BuildSettings defines model for buildSettings.
 type BuildSettings struct {
-	BranchWildcard       *string `json:"branchWildcard,omitempty"`
-	BuildAllBranches     bool    `json:"buildAllBranches"`
-	BuildAllPullRequests bool    `json:"buildAllPullRequests"`
-	DockerFilePath       *string `json:"dockerFilePath,omitempty"`
+	// BranchFilter glob patterns, or regular expressions wrapped in slashes
+	BranchFilter         *PatternFilterModel         `json:"branchFilter,omitempty"`
+	BranchWildcard       *string                     `json:"branchWildcard,omitempty"`
+	BuildAllBranches     bool                        `json:"buildAllBranches"`
+	BuildAllPullRequests bool                        `json:"buildAllPullRequests"`
+	BuildArgs            *[]EnvironmentVariableModel `json:"buildArgs,omitempty"`
+
+	// ContextPath subdirectory of the repository that is used as build context, defaults to the root of the repository
+	ContextPath *string `json:"contextPath,omitempty"`
+
+	// DockerFilePath path of the Dockerfile relative to the build context, defaults to Dockerfile
+	DockerFilePath *string `json:"dockerFilePath,omitempty"`
+
+	// PullRequestAuthorFilter glob patterns, or regular expressions wrapped in slashes
+	PullRequestAuthorFilter *PatternFilterModel `json:"pullRequestAuthorFilter,omitempty"`
+
+	// PullRequestBaseFilter glob patterns, or regular expressions wrapped in slashes
+	PullRequestBaseFilter *PatternFilterModel `json:"pullRequestBaseFilter,omitempty"`
+
+	// PullRequestHeadFilter glob patterns, or regular expressions wrapped in slashes
+	PullRequestHeadFilter *PatternFilterModel `json:"pullRequestHeadFilter,omitempty"`
+	PullRequestLabel      *string             `json:"pullRequestLabel,omitempty"`
+	SkipDraftPullRequests *bool               `json:"skipDraftPullRequests,omitempty"`
+
+	// Target stage of a multi-stage Dockerfile that is built, defaults to the last stage
+	Target *string `json:"target,omitempty"`
 }
 
 // ContainerSettingsModel defines model for containerSettingsModel.
@@ -51,10 +87,14 @@ type EnvironmentVariableModel struct {
 
 // GitSettingsModel defines model for gitSettingsModel.
 type GitSettingsModel struct {
-	Organization string `json:"organization"`
-	Repository   string `json:"repository"`
+	Organization string                    `json:"organization"`
+	Provider     *GitSettingsModelProvider `json:"provider,omitempty"`
+	Repository   string                    `json:"repository"`
 }
 
+// GitSettingsModelProvider defines model for GitSettingsModel.Provider.
+type GitSettingsModelProvider string
+
 // GithubRepositoryModel defines model for githubRepositoryModel.
 type GithubRepositoryModel struct {
 	Name  string `json:"name"`
@@ -69,20 +109,34 @@ type GithubUsernameSearchResponseModel struct {
 
 // InstanceGitSettingsModel defines model for instanceGitSettingsModel.
 type InstanceGitSettingsModel struct {
-	Branch                *string `json:"branch,omitempty"`
-	CommitHash            *string `json:"commitHash,omitempty"`
+	Branch     *string `json:"branch,omitempty"`
+	CommitHash *string `json:"commitHash,omitempty"`
+
+	// Fork the pull request comes from a fork, its commits have to be approved before they are built
+	Fork                  *bool   `json:"fork,omitempty"`
 	PullRequestIdentifier *string `json:"pullRequestIdentifier,omitempty"`
 }
 
+// PatternFilterModel glob patterns, or regular expressions wrapped in slashes
+type PatternFilterModel struct {
+	Exclude *[]string `json:"exclude,omitempty"`
+	Include *[]string `json:"include,omitempty"`
+}
+
 // PreviewEnvironmentInstanceModel defines model for previewEnvironmentInstanceModel.
 type PreviewEnvironmentInstanceModel struct {
+	CommitUrl            *string                  `json:"commitUrl,omitempty"`
 	CurrentPhase         string                   `json:"currentPhase"`
 	DesiredPhase         string                   `json:"desiredPhase"`
+	ExpiresAt            *time.Time               `json:"expiresAt,omitempty"`
 	InstanceGitSettings  InstanceGitSettingsModel `json:"instanceGitSettings"`
 	Name                 string                   `json:"name"`
 	OwnerId              string                   `json:"ownerId"`
 	PreviewEnvironmentId string                   `json:"previewEnvironmentId"`
-	PublicFacingUrl      *string                  `json:"publicFacingUrl,omitempty"`
+
+	// PreviousCommitUrls urls of the previously deployed commits that still run, newest first
+	PreviousCommitUrls *[]string `json:"previousCommitUrls,omitempty"`
+	PublicFacingUrl    *string   `json:"publicFacingUrl,omitempty"`
 }
 
 // PreviewEnvironmentModel defines model for previewEnvironmentModel.
@@ -90,10 +144,13 @@ type PreviewEnvironmentModel struct {
 	AccessSettings      AccessSettingsModel      `json:"accessSettings"`
 	ApplicationSettings ApplicationSettingsModel `json:"applicationSettings"`
 	BuildSettings       BuildSettings            `json:"buildSettings"`
-	ContainerSettings   ContainerSettingsModel   `json:"containerSettings"`
-	GitSettings         GitSettingsModel         `json:"gitSettings"`
-	Id                  string                   `json:"id"`
-	Name                string                   `json:"name"`
+
+	// CommentTemplate go template of the comment that is kept up to date in every pull request, the default template is used if empty
+	CommentTemplate   *string                `json:"commentTemplate,omitempty"`
+	ContainerSettings ContainerSettingsModel `json:"containerSettings"`
+	GitSettings       GitSettingsModel       `json:"gitSettings"`
+	Id                string                 `json:"id"`
+	Name              string                 `json:"name"`
 }
 
 // ServerHttpError defines model for server.httpError.
@@ -113,6 +170,33 @@ type PostEnvironmentParams struct {
 	Authentication string `json:"authentication"`
 }
 
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams defines parameters for PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove.
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams struct {
+	// Authentication Authentication token
+	Authentication string `json:"authentication"`
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams defines parameters for PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend.
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams struct {
+	// Duration How long the instance should be extended, e.g. 24h or 3d, defaults to 24h
+	Duration *string `form:"duration,omitempty" json:"duration,omitempty"`
+
+	// Authentication Authentication token
+	Authentication string `json:"authentication"`
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams defines parameters for PatchEnvironmentInstanceEnvironmentIdInstanceNameStart.
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams struct {
+	// Authentication Authentication token
+	Authentication string `json:"authentication"`
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams defines parameters for PatchEnvironmentInstanceEnvironmentIdInstanceNameStop.
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams struct {
+	// Authentication Authentication token
+	Authentication string `json:"authentication"`
+}
+
 // GetEnvironmentInstanceIdListParams defines parameters for GetEnvironmentInstanceIdList.
 type GetEnvironmentInstanceIdListParams struct {
 	// Authentication Authentication token
@@ -152,6 +236,9 @@ type GetGithubRepositoriesParams struct {
 // PostEnvironmentJSONRequestBody defines body for PostEnvironment for application/json ContentType.
 type PostEnvironmentJSONRequestBody = PreviewEnvironmentModel
 
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveJSONRequestBody defines body for PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove for application/json ContentType.
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveJSONRequestBody = ApproveInstanceModel
+
 // ServerInterface represents all server handlers.
 type ServerInterface interface {
 	// Get the userId for a given username
@@ -160,6 +247,18 @@ type ServerInterface interface {
 	// Creates a new environment
 	// (POST /environment)
 	PostEnvironment(ctx echo.Context, params PostEnvironmentParams) error
+	// Approves the build of a fork pull request
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/approve)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams) error
+	// Extends the lifetime of an instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/extend)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams) error
+	// Starts a stopped instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/start)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams) error
+	// Stops an instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/stop)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams) error
 	// Lists all instances of an environment
 	// (GET /environment-instance/{id}/list)
 	GetEnvironmentInstanceIdList(ctx echo.Context, id string, params GetEnvironmentInstanceIdListParams) error
@@ -254,6 +353,196 @@ func (w *ServerInterfaceWrapper) PostEnvironment(ctx echo.Context) error {
 	return err
 }
 
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove converts echo context to params.
+func (w *ServerInterfaceWrapper) PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "environmentId" -------------
+	var environmentId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "environmentId", ctx.Param("environmentId"), &environmentId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter environmentId: %s", err))
+	}
+
+	// ------------- Path parameter "instanceName" -------------
+	var instanceName string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "instanceName", ctx.Param("instanceName"), &instanceName, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter instanceName: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams
+
+	headers := ctx.Request().Header
+	// ------------- Required header parameter "authentication" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("authentication")]; found {
+		var Authentication string
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authentication, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "authentication", valueList[0], &Authentication, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: true})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authentication: %s", err))
+		}
+
+		params.Authentication = Authentication
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Header parameter authentication is required, but not found"))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx, environmentId, instanceName, params)
+	return err
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend converts echo context to params.
+func (w *ServerInterfaceWrapper) PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "environmentId" -------------
+	var environmentId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "environmentId", ctx.Param("environmentId"), &environmentId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter environmentId: %s", err))
+	}
+
+	// ------------- Path parameter "instanceName" -------------
+	var instanceName string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "instanceName", ctx.Param("instanceName"), &instanceName, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter instanceName: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams
+	// ------------- Optional query parameter "duration" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "duration", ctx.QueryParams(), &params.Duration)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter duration: %s", err))
+	}
+
+	headers := ctx.Request().Header
+	// ------------- Required header parameter "authentication" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("authentication")]; found {
+		var Authentication string
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authentication, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "authentication", valueList[0], &Authentication, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: true})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authentication: %s", err))
+		}
+
+		params.Authentication = Authentication
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Header parameter authentication is required, but not found"))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx, environmentId, instanceName, params)
+	return err
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStart converts echo context to params.
+func (w *ServerInterfaceWrapper) PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "environmentId" -------------
+	var environmentId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "environmentId", ctx.Param("environmentId"), &environmentId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter environmentId: %s", err))
+	}
+
+	// ------------- Path parameter "instanceName" -------------
+	var instanceName string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "instanceName", ctx.Param("instanceName"), &instanceName, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter instanceName: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams
+
+	headers := ctx.Request().Header
+	// ------------- Required header parameter "authentication" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("authentication")]; found {
+		var Authentication string
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authentication, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "authentication", valueList[0], &Authentication, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: true})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authentication: %s", err))
+		}
+
+		params.Authentication = Authentication
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Header parameter authentication is required, but not found"))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx, environmentId, instanceName, params)
+	return err
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStop converts echo context to params.
+func (w *ServerInterfaceWrapper) PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx echo.Context) error {
+	var err error
+	// ------------- Path parameter "environmentId" -------------
+	var environmentId string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "environmentId", ctx.Param("environmentId"), &environmentId, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter environmentId: %s", err))
+	}
+
+	// ------------- Path parameter "instanceName" -------------
+	var instanceName string
+
+	err = runtime.BindStyledParameterWithOptions("simple", "instanceName", ctx.Param("instanceName"), &instanceName, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationPath, Explode: false, Required: true})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter instanceName: %s", err))
+	}
+
+	// Parameter object where we will unmarshal all parameters from the context
+	var params PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams
+
+	headers := ctx.Request().Header
+	// ------------- Required header parameter "authentication" -------------
+	if valueList, found := headers[http.CanonicalHeaderKey("authentication")]; found {
+		var Authentication string
+		n := len(valueList)
+		if n != 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Expected one value for authentication, got %d", n))
+		}
+
+		err = runtime.BindStyledParameterWithOptions("simple", "authentication", valueList[0], &Authentication, runtime.BindStyledParameterOptions{ParamLocation: runtime.ParamLocationHeader, Explode: false, Required: true})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter authentication: %s", err))
+		}
+
+		params.Authentication = Authentication
+	} else {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Header parameter authentication is required, but not found"))
+	}
+
+	// Invoke the callback with all the unmarshaled arguments
+	err = w.Handler.PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx, environmentId, instanceName, params)
+	return err
+}
+
 // GetEnvironmentInstanceIdList converts echo context to params.
 func (w *ServerInterfaceWrapper) GetEnvironmentInstanceIdList(ctx echo.Context) error {
 	var err error
@@ -514,6 +803,10 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 
 	router.GET(baseURL+"/account/userIdForUsername/:username", wrapper.GetAccountUserIdForUsernameUsername)
 	router.POST(baseURL+"/environment", wrapper.PostEnvironment)
+	router.PATCH(baseURL+"/environment-instance/:environmentId/:instanceName/approve", wrapper.PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove)
+	router.PATCH(baseURL+"/environment-instance/:environmentId/:instanceName/extend", wrapper.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend)
+	router.PATCH(baseURL+"/environment-instance/:environmentId/:instanceName/start", wrapper.PatchEnvironmentInstanceEnvironmentIdInstanceNameStart)
+	router.PATCH(baseURL+"/environment-instance/:environmentId/:instanceName/stop", wrapper.PatchEnvironmentInstanceEnvironmentIdInstanceNameStop)
 	router.GET(baseURL+"/environment-instance/:id/list", wrapper.GetEnvironmentInstanceIdList)
 	router.PATCH(baseURL+"/environment/addUser/:environmentId/:userId", wrapper.PatchEnvironmentAddUserEnvironmentIdUserId)
 	router.GET(baseURL+"/environment/list", wrapper.GetEnvironmentList)
@@ -613,6 +906,218 @@ func (response PostEnvironment500JSONResponse) VisitPostEnvironmentResponse(w ht
 	return json.NewEncoder(w).Encode(response)
 }
 
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveRequestObject struct {
+	EnvironmentId string `json:"environmentId"`
+	InstanceName  string `json:"instanceName"`
+	Params        PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams
+	Body          *PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveJSONRequestBody
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponseObject interface {
+	VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove200JSONResponse PreviewEnvironmentInstanceModel
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove200JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove400JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove400JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove401JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove401JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove404JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove404JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove409JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove409JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(409)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove500JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove500JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendRequestObject struct {
+	EnvironmentId string `json:"environmentId"`
+	InstanceName  string `json:"instanceName"`
+	Params        PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponseObject interface {
+	VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend200JSONResponse PreviewEnvironmentInstanceModel
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend200JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend400JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend400JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend401JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend401JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend404JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend404JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend500JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend500JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStartRequestObject struct {
+	EnvironmentId string `json:"environmentId"`
+	InstanceName  string `json:"instanceName"`
+	Params        PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponseObject interface {
+	VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(w http.ResponseWriter) error
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStart200JSONResponse PreviewEnvironmentInstanceModel
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStart200JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStart401JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStart401JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStart404JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStart404JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStart500JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStart500JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStopRequestObject struct {
+	EnvironmentId string `json:"environmentId"`
+	InstanceName  string `json:"instanceName"`
+	Params        PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponseObject interface {
+	VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(w http.ResponseWriter) error
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStop200JSONResponse PreviewEnvironmentInstanceModel
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStop200JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStop401JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStop401JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStop404JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStop404JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(404)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
+type PatchEnvironmentInstanceEnvironmentIdInstanceNameStop500JSONResponse ServerHttpError
+
+func (response PatchEnvironmentInstanceEnvironmentIdInstanceNameStop500JSONResponse) VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(500)
+
+	return json.NewEncoder(w).Encode(response)
+}
+
 type GetEnvironmentInstanceIdListRequestObject struct {
 	Id     string `json:"id"`
 	Params GetEnvironmentInstanceIdListParams
@@ -864,6 +1369,18 @@ type StrictServerInterface interface {
 	// Creates a new environment
 	// (POST /environment)
 	PostEnvironment(ctx context.Context, request PostEnvironmentRequestObject) (PostEnvironmentResponseObject, error)
+	// Approves the build of a fork pull request
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/approve)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx context.Context, request PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveRequestObject) (PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponseObject, error)
+	// Extends the lifetime of an instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/extend)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx context.Context, request PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendRequestObject) (PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponseObject, error)
+	// Starts a stopped instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/start)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx context.Context, request PatchEnvironmentInstanceEnvironmentIdInstanceNameStartRequestObject) (PatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponseObject, error)
+	// Stops an instance
+	// (PATCH /environment-instance/{environmentId}/{instanceName}/stop)
+	PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx context.Context, request PatchEnvironmentInstanceEnvironmentIdInstanceNameStopRequestObject) (PatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponseObject, error)
 	// Lists all instances of an environment
 	// (GET /environment-instance/{id}/list)
 	GetEnvironmentInstanceIdList(ctx context.Context, request GetEnvironmentInstanceIdListRequestObject) (GetEnvironmentInstanceIdListResponseObject, error)
@@ -953,6 +1470,120 @@ func (sh *strictHandler) PostEnvironment(ctx echo.Context, params PostEnvironmen
 	return nil
 }
 
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove operation middleware
+func (sh *strictHandler) PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveParams) error {
+	var request PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveRequestObject
+
+	request.EnvironmentId = environmentId
+	request.InstanceName = instanceName
+	request.Params = params
+
+	var body PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveJSONRequestBody
+	if err := ctx.Bind(&body); err != nil {
+		return err
+	}
+	request.Body = &body
+
+	handler := func(ctx echo.Context, request interface{}) (interface{}, error) {
+		return sh.ssi.PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx.Request().Context(), request.(PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove")
+	}
+
+	response, err := handler(ctx, request)
+
+	if err != nil {
+		return err
+	} else if validResponse, ok := response.(PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponseObject); ok {
+		return validResponse.VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponse(ctx.Response())
+	} else if response != nil {
+		return fmt.Errorf("unexpected response type: %T", response)
+	}
+	return nil
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend operation middleware
+func (sh *strictHandler) PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendParams) error {
+	var request PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendRequestObject
+
+	request.EnvironmentId = environmentId
+	request.InstanceName = instanceName
+	request.Params = params
+
+	handler := func(ctx echo.Context, request interface{}) (interface{}, error) {
+		return sh.ssi.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx.Request().Context(), request.(PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend")
+	}
+
+	response, err := handler(ctx, request)
+
+	if err != nil {
+		return err
+	} else if validResponse, ok := response.(PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponseObject); ok {
+		return validResponse.VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponse(ctx.Response())
+	} else if response != nil {
+		return fmt.Errorf("unexpected response type: %T", response)
+	}
+	return nil
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStart operation middleware
+func (sh *strictHandler) PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameStartParams) error {
+	var request PatchEnvironmentInstanceEnvironmentIdInstanceNameStartRequestObject
+
+	request.EnvironmentId = environmentId
+	request.InstanceName = instanceName
+	request.Params = params
+
+	handler := func(ctx echo.Context, request interface{}) (interface{}, error) {
+		return sh.ssi.PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx.Request().Context(), request.(PatchEnvironmentInstanceEnvironmentIdInstanceNameStartRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "PatchEnvironmentInstanceEnvironmentIdInstanceNameStart")
+	}
+
+	response, err := handler(ctx, request)
+
+	if err != nil {
+		return err
+	} else if validResponse, ok := response.(PatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponseObject); ok {
+		return validResponse.VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponse(ctx.Response())
+	} else if response != nil {
+		return fmt.Errorf("unexpected response type: %T", response)
+	}
+	return nil
+}
+
+// PatchEnvironmentInstanceEnvironmentIdInstanceNameStop operation middleware
+func (sh *strictHandler) PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx echo.Context, environmentId string, instanceName string, params PatchEnvironmentInstanceEnvironmentIdInstanceNameStopParams) error {
+	var request PatchEnvironmentInstanceEnvironmentIdInstanceNameStopRequestObject
+
+	request.EnvironmentId = environmentId
+	request.InstanceName = instanceName
+	request.Params = params
+
+	handler := func(ctx echo.Context, request interface{}) (interface{}, error) {
+		return sh.ssi.PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx.Request().Context(), request.(PatchEnvironmentInstanceEnvironmentIdInstanceNameStopRequestObject))
+	}
+	for _, middleware := range sh.middlewares {
+		handler = middleware(handler, "PatchEnvironmentInstanceEnvironmentIdInstanceNameStop")
+	}
+
+	response, err := handler(ctx, request)
+
+	if err != nil {
+		return err
+	} else if validResponse, ok := response.(PatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponseObject); ok {
+		return validResponse.VisitPatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponse(ctx.Response())
+	} else if response != nil {
+		return fmt.Errorf("unexpected response type: %T", response)
+	}
+	return nil
+}
+
 // GetEnvironmentInstanceIdList operation middleware
 func (sh *strictHandler) GetEnvironmentInstanceIdList(ctx echo.Context, id string, params GetEnvironmentInstanceIdListParams) error {
 	var request GetEnvironmentInstanceIdListRequestObject
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/git"
 	apigen "github.com/coflnet/pr-env/internal/server/openapi"
 	"github.com/labstack/echo/v4"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -37,18 +38,31 @@ func (s Server) PostEnvironment(ctx context.Context, request apigen.PostEnvironm
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	err = s.kubeClient.CreatePreviewEnvironment(ctx, convertFromEnvironmentModel(userId, *request.Body))
+	pe := convertFromEnvironmentModel(userId, *request.Body)
+	for _, filter := range []*coflnetv1alpha1.PatternFilter{pe.Spec.BuildSettings.BranchFilter, pe.Spec.BuildSettings.PullRequestHeadFilter, pe.Spec.BuildSettings.PullRequestBaseFilter, pe.Spec.BuildSettings.PullRequestAuthorFilter} {
+		if err := filter.Validate(); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if err := pe.Spec.BuildSettings.ValidateBuild(); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if _, err := git.ParseCommentTemplate(pe.CommentTemplate()); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = s.kubeClient.CreatePreviewEnvironment(ctx, pe)
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	name := coflnetv1alpha1.PreviewEnvironmentName(request.Body.GitSettings.Organization, request.Body.GitSettings.Repository)
-	pe, err := s.kubeClient.PreviewEnvironmentByName(ctx, userId, name)
+	created, err := s.kubeClient.PreviewEnvironmentByName(ctx, userId, name)
 	if err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	return apigen.PostEnvironment200JSONResponse(convertToEnvironmentModel(pe)), nil
+	return apigen.PostEnvironment200JSONResponse(convertToEnvironmentModel(created)), nil
 }
 
 // Deletes an environment
@@ -174,10 +188,19 @@ func convertFromEnvironmentModel(userId string, in apigen.PreviewEnvironmentMode
 				IngressHostname:      "tmpenv.app",
 			},
 			BuildSettings: coflnetv1alpha1.BuildSettings{
-				BranchWildcard:       in.BuildSettings.BranchWildcard,
-				BuildAllBranches:     in.BuildSettings.BuildAllBranches,
-				BuildAllPullRequests: in.BuildSettings.BuildAllPullRequests,
-				DockerfilePath:       in.BuildSettings.DockerFilePath,
+				BranchWildcard:          in.BuildSettings.BranchWildcard,
+				BranchFilter:            patternFilterFromModel(in.BuildSettings.BranchFilter),
+				PullRequestHeadFilter:   patternFilterFromModel(in.BuildSettings.PullRequestHeadFilter),
+				PullRequestBaseFilter:   patternFilterFromModel(in.BuildSettings.PullRequestBaseFilter),
+				PullRequestAuthorFilter: patternFilterFromModel(in.BuildSettings.PullRequestAuthorFilter),
+				PullRequestLabel:        in.BuildSettings.PullRequestLabel,
+				SkipDraftPullRequests:   in.BuildSettings.SkipDraftPullRequests != nil && *in.BuildSettings.SkipDraftPullRequests,
+				BuildAllBranches:        in.BuildSettings.BuildAllBranches,
+				BuildAllPullRequests:    in.BuildSettings.BuildAllPullRequests,
+				DockerfilePath:          in.BuildSettings.DockerFilePath,
+				ContextPath:             in.BuildSettings.ContextPath,
+				BuildArgs:               buildArgsFromModel(in.BuildSettings.BuildArgs),
+				Target:                  in.BuildSettings.Target,
 			},
 			ContainerRegistry: &coflnetv1alpha1.ContainerRegistry{
 				Registry:   "index.docker.io",
@@ -186,7 +209,9 @@ func convertFromEnvironmentModel(userId string, in apigen.PreviewEnvironmentMode
 			GitSettings: coflnetv1alpha1.GitSettings{
 				Organization: in.GitSettings.Organization,
 				Repository:   in.GitSettings.Repository,
+				Provider:     gitProviderFromModel(in.GitSettings.Provider),
 			},
+			CommentTemplate: in.CommentTemplate,
 		},
 	}
 }
@@ -233,10 +258,19 @@ func convertToEnvironmentModel(in *coflnetv1alpha1.PreviewEnvironment) apigen.Pr
 			Port:                 in.Spec.ApplicationSettings.Port,
 		},
 		BuildSettings: apigen.BuildSettings{
-			BranchWildcard:       in.Spec.BuildSettings.BranchWildcard,
-			BuildAllBranches:     in.Spec.BuildSettings.BuildAllBranches,
-			BuildAllPullRequests: in.Spec.BuildSettings.BuildAllPullRequests,
-			DockerFilePath:       in.Spec.BuildSettings.DockerfilePath,
+			BranchWildcard:          in.Spec.BuildSettings.BranchWildcard,
+			BranchFilter:            patternFilterToModel(in.Spec.BuildSettings.BranchFilter),
+			PullRequestHeadFilter:   patternFilterToModel(in.Spec.BuildSettings.PullRequestHeadFilter),
+			PullRequestBaseFilter:   patternFilterToModel(in.Spec.BuildSettings.PullRequestBaseFilter),
+			PullRequestAuthorFilter: patternFilterToModel(in.Spec.BuildSettings.PullRequestAuthorFilter),
+			PullRequestLabel:        in.Spec.BuildSettings.PullRequestLabel,
+			SkipDraftPullRequests:   &in.Spec.BuildSettings.SkipDraftPullRequests,
+			BuildAllBranches:        in.Spec.BuildSettings.BuildAllBranches,
+			BuildAllPullRequests:    in.Spec.BuildSettings.BuildAllPullRequests,
+			DockerFilePath:          in.Spec.BuildSettings.DockerfilePath,
+			ContextPath:             in.Spec.BuildSettings.ContextPath,
+			BuildArgs:               buildArgsToModel(in.Spec.BuildSettings.BuildArgs),
+			Target:                  in.Spec.BuildSettings.Target,
 		},
 		ContainerSettings: apigen.ContainerSettingsModel{
 			Registry:   &in.Spec.ContainerRegistry.Registry,
@@ -245,8 +279,67 @@ func convertToEnvironmentModel(in *coflnetv1alpha1.PreviewEnvironment) apigen.Pr
 		GitSettings: apigen.GitSettingsModel{
 			Organization: in.Spec.GitSettings.Organization,
 			Repository:   in.Spec.GitSettings.Repository,
+			Provider:     gitProviderToModel(in.Spec.GitSettings.ProviderOrDefault()),
 		},
-		Id:   string(in.GetUID()),
-		Name: in.GetName(),
+		CommentTemplate: in.Spec.CommentTemplate,
+		Id:              string(in.GetUID()),
+		Name:            in.GetName(),
+	}
+}
+
+func gitProviderFromModel(in *apigen.GitSettingsModelProvider) string {
+	if in == nil {
+		return ""
+	}
+	return string(*in)
+}
+
+func gitProviderToModel(in string) *apigen.GitSettingsModelProvider {
+	p := apigen.GitSettingsModelProvider(in)
+	return &p
+}
+
+func patternFilterFromModel(in *apigen.PatternFilterModel) *coflnetv1alpha1.PatternFilter {
+	if in == nil {
+		return nil
+	}
+
+	filter := &coflnetv1alpha1.PatternFilter{}
+	if in.Include != nil {
+		filter.Include = *in.Include
+	}
+	if in.Exclude != nil {
+		filter.Exclude = *in.Exclude
+	}
+	return filter
+}
+
+func patternFilterToModel(in *coflnetv1alpha1.PatternFilter) *apigen.PatternFilterModel {
+	if in == nil {
+		return nil
+	}
+	return &apigen.PatternFilterModel{
+		Include: &in.Include,
+		Exclude: &in.Exclude,
+	}
+}
+
+func buildArgsFromModel(in *[]apigen.EnvironmentVariableModel) []coflnetv1alpha1.BuildArg {
+	if in == nil {
+		return nil
+	}
+
+	args := make([]coflnetv1alpha1.BuildArg, 0, len(*in))
+	for _, arg := range *in {
+		args = append(args, coflnetv1alpha1.BuildArg{Key: arg.Key, Value: arg.Value})
+	}
+	return args
+}
+
+func buildArgsToModel(in []coflnetv1alpha1.BuildArg) *[]apigen.EnvironmentVariableModel {
+	args := make([]apigen.EnvironmentVariableModel, 0, len(in))
+	for _, arg := range in {
+		args = append(args, apigen.EnvironmentVariableModel{Key: arg.Key, Value: arg.Value})
 	}
+	return &args
 }
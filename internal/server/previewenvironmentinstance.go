@@ -2,11 +2,18 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	coflnetv1alpha1 "github.com/coflnet/pr-env/api/v1alpha1"
+	"github.com/coflnet/pr-env/internal/kubeclient"
 	apigen "github.com/coflnet/pr-env/internal/server/openapi"
 	"github.com/labstack/echo/v4"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -27,6 +34,96 @@ func (s Server) GetEnvironmentInstanceIdList(ctx context.Context, request apigen
 	return apigen.GetEnvironmentInstanceIdList200JSONResponse(res), nil
 }
 
+// Starts a stopped instance
+// (PATCH /environment-instance/{environmentId}/{instanceName}/start)
+func (s Server) PatchEnvironmentInstanceEnvironmentIdInstanceNameStart(ctx context.Context, request apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStartRequestObject) (apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStartResponseObject, error) {
+	pei, err := s.setDesiredPhaseOfInstance(ctx, request.Params.Authentication, request.EnvironmentId, request.InstanceName, coflnetv1alpha1.InstancePhaseRunning)
+	if err != nil {
+		return nil, err
+	}
+	return apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStart200JSONResponse(convertToEnvironmentInstanceModel(*pei)), nil
+}
+
+// Stops an instance
+// (PATCH /environment-instance/{environmentId}/{instanceName}/stop)
+func (s Server) PatchEnvironmentInstanceEnvironmentIdInstanceNameStop(ctx context.Context, request apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStopRequestObject) (apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStopResponseObject, error) {
+	pei, err := s.setDesiredPhaseOfInstance(ctx, request.Params.Authentication, request.EnvironmentId, request.InstanceName, coflnetv1alpha1.InstancePhaseStopped)
+	if err != nil {
+		return nil, err
+	}
+	return apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameStop200JSONResponse(convertToEnvironmentInstanceModel(*pei)), nil
+}
+
+// Extends the lifetime of an instance
+// (PATCH /environment-instance/{environmentId}/{instanceName}/extend)
+func (s Server) PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend(ctx context.Context, request apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendRequestObject) (apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtendResponseObject, error) {
+	userId, err := s.userIdFromAuthenticationToken(ctx, request.Params.Authentication)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	duration := coflnetv1alpha1.DefaultExtension
+	if request.Params.Duration != nil {
+		duration, err = parseExtension(*request.Params.Duration)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Errorf("invalid duration %s", *request.Params.Duration))
+		}
+	}
+
+	pei, err := s.kubeClient.ExtendPreviewEnvironmentInstance(ctx, userId, types.UID(request.EnvironmentId), request.InstanceName, duration)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("instance %s of environment %s not found", request.InstanceName, request.EnvironmentId))
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameExtend200JSONResponse(convertToEnvironmentInstanceModel(*pei)), nil
+}
+
+// Approves the build of a fork pull request
+// (PATCH /environment-instance/{environmentId}/{instanceName}/approve)
+func (s Server) PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove(ctx context.Context, request apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveRequestObject) (apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameApproveResponseObject, error) {
+	userId, err := s.userIdFromAuthenticationToken(ctx, request.Params.Authentication)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	// the reviewed commit is required, approving whatever commit is current could approve a push after the review
+	if request.Body == nil || len(request.Body.CommitHash) < coflnetv1alpha1.MinReviewedCommitLength {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("the reviewed commit is required, use at least %d characters of it", coflnetv1alpha1.MinReviewedCommitLength))
+	}
+
+	pei, err := s.kubeClient.ApprovePreviewEnvironmentInstance(ctx, userId, types.UID(request.EnvironmentId), request.InstanceName, request.Body.CommitHash)
+	if err != nil {
+		if notReviewed, ok := err.(kubeclient.CommitNotReviewedError); ok {
+			return nil, echo.NewHTTPError(http.StatusConflict, notReviewed.Error())
+		}
+		if errors.IsNotFound(err) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("instance %s of environment %s not found", request.InstanceName, request.EnvironmentId))
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return apigen.PatchEnvironmentInstanceEnvironmentIdInstanceNameApprove200JSONResponse(convertToEnvironmentInstanceModel(*pei)), nil
+}
+
+func (s Server) setDesiredPhaseOfInstance(ctx context.Context, authentication, environmentId, instanceName, desiredPhase string) (*coflnetv1alpha1.PreviewEnvironmentInstance, error) {
+	userId, err := s.userIdFromAuthenticationToken(ctx, authentication)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	pei, err := s.kubeClient.SetDesiredPhaseOfPreviewEnvironmentInstance(ctx, userId, types.UID(environmentId), instanceName, desiredPhase)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Errorf("instance %s of environment %s not found", instanceName, environmentId))
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return pei, nil
+}
+
 func convertToEnvironmentInstanceModelList(peis coflnetv1alpha1.PreviewEnvironmentInstanceList) []apigen.PreviewEnvironmentInstanceModel {
 	res := make([]apigen.PreviewEnvironmentInstanceModel, 0, len(peis.Items))
 	for _, pei := range peis.Items {
@@ -43,17 +140,58 @@ func convertToEnvironmentInstanceModel(pei coflnetv1alpha1.PreviewEnvironmentIns
 			Branch:                pei.Spec.InstanceGitSettings.Branch,
 			CommitHash:            &pei.Spec.InstanceGitSettings.CommitHash,
 			PullRequestIdentifier: intPtrToStrPtr(pei.Spec.InstanceGitSettings.PullRequestNumber),
+			Fork:                  &pei.Spec.InstanceGitSettings.Fork,
 		},
 		Name:                 pei.GetName(),
 		OwnerId:              pei.GetOwner(),
 		PreviewEnvironmentId: pei.GetPreviewEnvironmentId(),
 		PublicFacingUrl:      &pei.Status.PublicFacingUrl,
+		CommitUrl:            &pei.Status.CommitUrl,
+		PreviousCommitUrls:   previousCommitUrls(pei.Status.PreviousCommits),
+		ExpiresAt:            metaTimePtrToTimePtr(pei.Status.ExpiresAt),
 	}
 }
 
+func previousCommitUrls(commits []coflnetv1alpha1.PreviousCommit) *[]string {
+	urls := []string{}
+	for _, c := range commits {
+		urls = append(urls, c.Url)
+	}
+	return &urls
+}
+
+func metaTimePtrToTimePtr(v *metav1.Time) *time.Time {
+	if v == nil {
+		return nil
+	}
+	return &v.Time
+}
+
 func intPtrToStrPtr(v *int) *string {
 	if v == nil {
 		return nil
 	}
 	return strPtr(strconv.Itoa(*v))
 }
+
+// parseExtension parses the duration of an extension, days are supported in addition to the go durations, e.g. 3d
+func parseExtension(v string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return 0, err
+		}
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration %s is not positive", v)
+	}
+	return d, nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExtension(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		{value: "3d", expected: 72 * time.Hour},
+		{value: "1d", expected: 24 * time.Hour},
+		{value: "48h", expected: 48 * time.Hour},
+		{value: "90m", expected: 90 * time.Minute},
+		{value: "1h30m", expected: 90 * time.Minute},
+		{value: "0d", err: true},
+		{value: "0s", err: true},
+		{value: "-1d", err: true},
+		{value: "-2h", err: true},
+		{value: "d", err: true},
+		{value: "1.5d", err: true},
+		{value: "3", err: true},
+		{value: "", err: true},
+		{value: "tomorrow", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			actual, err := parseExtension(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseExtension(%q) error = %v, expected error %v", tt.value, err, tt.err)
+			}
+			if actual != tt.expected {
+				t.Errorf("parseExtension(%q) = %s, expected %s", tt.value, actual, tt.expected)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 
+	"github.com/coflnet/pr-env/internal/events"
 	"github.com/coflnet/pr-env/internal/git"
 	"github.com/coflnet/pr-env/internal/keycloak"
 	"github.com/coflnet/pr-env/internal/kubeclient"
@@ -19,14 +20,16 @@ type Server struct {
 	kubeClient     *kubeclient.KubeClient
 	githubClient   *git.GithubClient
 	keycloakClient *keycloak.KeycloakClient
+	events         *events.Bus
 }
 
-func NewServer(ctx context.Context, logger *logr.Logger, githubClient *git.GithubClient, kubeClient *kubeclient.KubeClient, keycloak *keycloak.KeycloakClient) *echo.Echo {
+func NewServer(ctx context.Context, logger *logr.Logger, githubClient *git.GithubClient, kubeClient *kubeclient.KubeClient, keycloak *keycloak.KeycloakClient, bus *events.Bus) *echo.Echo {
 	s := Server{
 		githubClient:   githubClient,
 		kubeClient:     kubeClient,
 		keycloakClient: keycloak,
 		log:            logger,
+		events:         bus,
 	}
 
 	e := echo.New()
@@ -63,6 +66,7 @@ func NewServer(ctx context.Context, logger *logr.Logger, githubClient *git.Githu
 	e.Static("/api/openapi", staticDir())
 
 	e.GET("/api/github/setupUrl", s.ConfigureInstallation)
+	e.POST("/api/github/webhook", s.GithubWebhookHandler)
 
 	// everything else
 	strictServer := apigen.NewStrictHandler(s, []apigen.StrictMiddlewareFunc{})
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coflnet/pr-env/internal/kubeclient"
+	"github.com/go-logr/logr"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// wakingPage is shown while an idle instance is scaled up, it reloads itself until the instance answers
+const wakingPage = `<!DOCTYPE html><html><head><title>Preview is starting</title><meta http-equiv="refresh" content="5"></head><body style="font-family:sans-serif;text-align:center;margin-top:10%"><h1>This preview is starting</h1><p>It was scaled down because nobody used it, the page reloads once it is ready.</p></body></html>`
+
+// NewWakeServer serves the wake handler on its own listener
+// the listener is only reachable inside of the cluster, through the services the ingresses of idle instances point to
+func NewWakeServer(logger *logr.Logger, kubeClient *kubeclient.KubeClient) *echo.Echo {
+	s := Server{
+		kubeClient: kubeClient,
+		log:        logger,
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(middleware.Recover())
+
+	// requests to idle instances are forwarded here by their ingress
+	e.Any("/wake/:name", s.WakeInstance)
+	return e
+}
+
+// WakeInstance requests the wake up of an idle instance and shows a waiting page
+// the route is not part of the openapi spec, it is called by the ingress of the instance
+func (s Server) WakeInstance(c echo.Context) error {
+	name := c.Param("name")
+
+	phase, err := s.kubeClient.RequestWakeForPreviewEnvironmentInstance(c.Request().Context(), name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return echo.NewHTTPError(http.StatusNotFound, "instance not found")
+		}
+		s.log.Error(err, "Unable to wake instance", "name", name)
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	s.log.Info("Wake up requested", "name", name, "phase", phase)
+	c.Response().Header().Set("Retry-After", "5")
+	return c.HTML(http.StatusServiceUnavailable, wakingPage)
+}